@@ -0,0 +1,167 @@
+package consul
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/robsignorelli/configify"
+)
+
+// ChangeType describes what happened to a key between two refreshes.
+type ChangeType int
+
+const (
+	// KeyAdded means that the key didn't exist before, but it does now.
+	KeyAdded ChangeType = iota
+	// KeyModified means that the key's value is different than it was before.
+	KeyModified
+	// KeyDeleted means that the key existed before, but it doesn't anymore.
+	KeyDeleted
+)
+
+func (changeType ChangeType) String() string {
+	switch changeType {
+	case KeyAdded:
+		return "added"
+	case KeyModified:
+		return "modified"
+	case KeyDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// Change describes a single key whose value was added, modified or deleted in Consul.
+type Change struct {
+	// Key is the key relative to your namespace (i.e. what you'd pass to source.String()).
+	Key string
+	// QualifiedKey is the full key in Consul, including the namespace.
+	QualifiedKey string
+	// Type indicates whether the key was added, modified or deleted.
+	Type ChangeType
+	// OldValue is the raw value before the change. It's empty when the key was added.
+	OldValue string
+	// NewValue is the raw value after the change. It's empty when the key was deleted.
+	NewValue string
+	// OldIndex is the key's ModifyIndex before the change. It's 0 when the key was added.
+	OldIndex uint64
+	// NewIndex is the key's ModifyIndex after the change. It's 0 when the key was deleted.
+	NewIndex uint64
+}
+
+// ChangeSet contains all of the changes to your namespace that we picked up in one refresh.
+type ChangeSet struct {
+	// Index is the Consul index of the values after these changes.
+	Index uint64
+	// Changes contains one entry for each added, modified or deleted key (sorted by key).
+	Changes []Change
+	// Source is a snapshot of all of your values after these changes were applied.
+	Source configify.Source
+	// Previous is a snapshot of all of your values before these changes were applied.
+	Previous configify.Source
+}
+
+// KeyChange describes a change to a single key for WatchKey/WatchPrefix watchers. Along w/ the
+// raw values, you get snapshots from before and after the change so you can read the old and new
+// values as whatever type you need (e.g. change.Old.Int(change.Key)).
+type KeyChange struct {
+	Change
+	// Old is a snapshot of all of your values before the change.
+	Old configify.Source
+	// New is a snapshot of all of your values after the change.
+	New configify.Source
+}
+
+// diff compares the values in two snapshots and returns the keys whose effective values were
+// added, modified or deleted. Keys whose values are byte-for-byte the same are ignored even if
+// they were re-written in Consul (and have a new ModifyIndex) or if a different layer supplies
+// the same value now.
+func diff(before *snapshot, after *snapshot) []Change {
+	var changes []Change
+	for key, newEntry := range after.values {
+		oldEntry, ok := before.values[key]
+		switch {
+		case !ok:
+			changes = append(changes, newChange(key, KeyAdded, nil, newEntry.pair))
+		case !bytes.Equal(oldEntry.pair.Value, newEntry.pair.Value):
+			changes = append(changes, newChange(key, KeyModified, oldEntry.pair, newEntry.pair))
+		}
+	}
+	for key, oldEntry := range before.values {
+		if _, ok := after.values[key]; !ok {
+			changes = append(changes, newChange(key, KeyDeleted, oldEntry.pair, nil))
+		}
+	}
+	changes = append(changes, diffDocuments(before, after)...)
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+func newChange(key string, changeType ChangeType, oldPair *api.KVPair, newPair *api.KVPair) Change {
+	change := Change{Key: key, Type: changeType}
+	if oldPair != nil {
+		change.QualifiedKey = oldPair.Key
+		change.OldValue = string(oldPair.Value)
+		change.OldIndex = oldPair.ModifyIndex
+	}
+	if newPair != nil {
+		change.QualifiedKey = newPair.Key
+		change.NewValue = string(newPair.Value)
+		change.NewIndex = newPair.ModifyIndex
+	}
+	return change
+}
+
+// merge combines two consecutive change sets into one that describes going straight from the
+// values before the older set to the values after the newer set. Keys that changed and then
+// changed back (e.g. added then deleted) drop out entirely.
+func merge(older ChangeSet, newer ChangeSet) ChangeSet {
+	byKey := map[string]Change{}
+	for _, change := range older.Changes {
+		byKey[change.Key] = change
+	}
+	for _, change := range newer.Changes {
+		existing, ok := byKey[change.Key]
+		if !ok {
+			byKey[change.Key] = change
+			continue
+		}
+
+		combined := change
+		combined.OldValue = existing.OldValue
+		combined.OldIndex = existing.OldIndex
+		existedBefore := existing.Type != KeyAdded
+		existsAfter := change.Type != KeyDeleted
+		switch {
+		case existedBefore && existsAfter && combined.OldValue != combined.NewValue:
+			combined.Type = KeyModified
+		case !existedBefore && existsAfter:
+			combined.Type = KeyAdded
+		case existedBefore && !existsAfter:
+			combined.Type = KeyDeleted
+		default:
+			delete(byKey, change.Key)
+			continue
+		}
+		byKey[change.Key] = combined
+	}
+
+	changes := make([]Change, 0, len(byKey))
+	for _, change := range byKey {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return ChangeSet{
+		Index:    newer.Index,
+		Changes:  changes,
+		Source:   newer.Source,
+		Previous: older.Previous,
+	}
+}
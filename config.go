@@ -0,0 +1,167 @@
+package consul
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/robsignorelli/configify"
+)
+
+// Config contains the Consul-specific settings for a source that don't have a home in the
+// standard configify options. The zero value is perfectly valid and behaves just like NewSource.
+type Config struct {
+	// Client is an existing Consul client to use instead of building one from the Address,
+	// Username and Password options.
+	Client *api.Client
+	// Token is the ACL token sent w/ every request. When neither Token nor TokenFile are set,
+	// we fall back to the standard CONSUL_HTTP_TOKEN and CONSUL_HTTP_TOKEN_FILE env variables
+	// (unless you supplied a Client, in which case we use the client's own token).
+	Token string
+	// TokenFile is the path to a file containing the ACL token. It takes precedence over Token
+	// and is re-read whenever Consul rejects our token so rotated tokens are picked up w/o a restart.
+	TokenFile string
+	// TLS configures HTTPS/mutual TLS connections to the agent. It's ignored when you supply
+	// your own Client, since that should already be configured however you like.
+	TLS TLSConfig
+	// OnError is called (from the background refresh goroutine) whenever we fail to refresh
+	// values from Consul. The error is always a *RefreshError, so you can check its Kind to
+	// decide how loudly to complain. Keep it quick since it blocks the next refresh.
+	OnError func(err error)
+	// Startup controls what happens when we can't load values from Consul in NewSource. By
+	// default, we log nothing, return a source w/ no values and keep trying in the background.
+	Startup StartupPolicy
+	// Debounce makes us wait until your namespace has been quiet for this long before notifying
+	// watchers, merging all of the changes in the meantime into a single notification. This is
+	// handy when a script writes a bunch of keys one at a time. Lookups always see new values
+	// right away; only the notifications are delayed.
+	Debounce time.Duration
+	// DebounceMaxWait caps how long we'll hold onto notifications while changes keep trickling
+	// in. When it's zero, we wait for as long as it takes for things to quiet down.
+	DebounceMaxWait time.Duration
+	// Validate lets you inspect new values before we start serving them. If it returns an error,
+	// we keep serving the last values that passed validation, report a KindInvalid error through
+	// OnError, and try again the next time something changes in Consul.
+	Validate func(candidate configify.Source) error
+	// Schema declares the keys you expect in your namespace along w/ their types, defaults and
+	// constraints. We check it before your Validate function; values that violate it are rejected
+	// just like values that your Validate function rejects.
+	Schema Schema
+	// Strict makes the narrow numeric getters (Int8, Uint16, Float32 and friends) return false
+	// when a value doesn't fit in the type rather than silently wrapping around, so that
+	// HTTP_PORT=70000 isn't read as an Int16 of 4464.
+	Strict bool
+	// Secrets are keys (or path.Match patterns like "*_PASSWORD") whose values are redacted when
+	// you dump your config w/ All() or Prefix(). You can also mark schema fields as Secret. Like
+	// any other key, the patterns go through your KeyMapping.
+	Secrets []string
+	// Layers are more key prefixes (e.g. "svc/orders", "global") that we fall back to when a key
+	// isn't in your namespace. They're checked in order, so list them from most to least
+	// specific. We long-poll them along w/ your namespace, so changes to them are picked up right
+	// away.
+	Layers []string
+	// Overrides lets you override values for just this node or for instances w/ certain service
+	// tags, which is handy for canary rollouts.
+	Overrides OverrideConfig
+	// FlagPrefix is where we look for feature flag definitions (e.g. "flags"), relative to your
+	// namespace. Leave it empty if you don't use feature flags, and nothing gets treated as one.
+	FlagPrefix string
+	// Documents are keys (relative to your namespace) whose values are entire structured
+	// documents, along w/ the Decoder for each one (nil means JSON). You can read the values
+	// inside of them using "KEY#path" (e.g. source.Int("config.json#db.port")).
+	Documents map[string]Decoder
+	// KeyMapping translates keys so that your code can ask for "HTTP_HOST" when the key in Consul
+	// is "http/host" (see SlashToUnderscore, CaseInsensitive and ChainKeyMappings). It's applied
+	// to lookups, Keys() and change notifications alike. By default, keys must match exactly.
+	KeyMapping KeyMapping
+}
+
+// StartupPolicy lets you refuse to start up w/o config. When the initial load is Required, we
+// keep retrying (backing off exponentially) until Timeout has passed. If we still don't have
+// values by then, NewSource returns an error wrapping the last *RefreshError.
+type StartupPolicy struct {
+	// Required makes NewSource fail when it can't load the initial values from Consul.
+	Required bool
+	// Timeout is the total amount of time we'll spend trying to load the initial values. When
+	// it's zero, we only make a single attempt.
+	Timeout time.Duration
+	// Backoff is how long we wait before our first retry. Each subsequent retry waits twice
+	// as long as the previous one. Defaults to 100ms.
+	Backoff time.Duration
+	// MaxBackoff caps how long we'll wait between retries. Defaults to 5s.
+	MaxBackoff time.Duration
+}
+
+func (policy StartupPolicy) backoff() (time.Duration, time.Duration) {
+	backoff, maxBackoff := policy.Backoff, policy.MaxBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 5 * time.Second
+	}
+	return backoff, maxBackoff
+}
+
+func (config Config) flagPrefix(delimiter string, mapKey KeyMapping) string {
+	if config.FlagPrefix == "" {
+		return ""
+	}
+	return mapKey(config.FlagPrefix + delimiter)
+}
+
+// namespaceDelimiter is the delimiter that configify uses to join your namespace and keys. Like
+// configify, we fall back to "_" when you don't set one.
+func namespaceDelimiter(options configify.Options) string {
+	if delimiter := strings.TrimSpace(options.Namespace.Delimiter); delimiter != "" {
+		return delimiter
+	}
+	return "_"
+}
+
+// resolveToken figures out which ACL token/token file we should use, honoring the standard
+// Consul environment variables when you haven't explicitly configured one. When you supply your
+// own Client, it already has whatever token you want, so we leave the env variables to it.
+func (config Config) resolveToken() (token string, tokenFile string) {
+	if config.Token != "" || config.TokenFile != "" || config.Client != nil {
+		return config.Token, config.TokenFile
+	}
+	return os.Getenv(api.HTTPTokenEnvName), os.Getenv(api.HTTPTokenFileEnvName)
+}
+
+func readTokenFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func toConsulConfig(options configify.Options, config Config, token string) (*api.Config, error) {
+	consulConfig := api.DefaultConfig()
+	consulConfig.Address = options.Address
+	consulConfig.Token = token
+	// We've already read the token file ourselves, so don't let the client read it again.
+	consulConfig.TokenFile = ""
+	if options.Username != "" || options.Password != "" {
+		consulConfig.HttpAuth = &api.HttpBasicAuth{
+			Username: options.Username,
+			Password: options.Password,
+		}
+	}
+	if config.TLS.enabled() {
+		tlsConfig, err := newTLSClientConfig(config.TLS, options.Address)
+		if err != nil {
+			return nil, errors.Wrap(err, "tls error")
+		}
+		transport := consulConfig.Transport
+		transport.TLSClientConfig = tlsConfig
+		consulConfig.HttpClient = &http.Client{Transport: transport}
+		consulConfig.Scheme = "https"
+	}
+	return consulConfig, nil
+}
@@ -1,7 +1,10 @@
 package consul
 
 import (
+	"context"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/consul/api"
@@ -9,10 +12,28 @@ import (
 	"github.com/robsignorelli/configify"
 )
 
-// NewSource creates a new config source that is backed by a Consul Key/Value store. You
-// provide the consul client (so you can share connections w/ your service discovery and such)
-// and this will extract config values for you.
-func NewSource(opts ...configify.Option) (configify.SourceWatcher, error) {
+// NewSource creates a new config source that is backed by a Consul Key/Value store. It
+// connects to the Consul agent at the Address option and extracts config values for you. If
+// you already have a Consul client (say for service discovery), use NewSourceFromClient instead.
+func NewSource(opts ...configify.Option) (Source, error) {
+	return NewSourceWithConfig(Config{}, opts...)
+}
+
+// NewSourceFromClient creates a new config source backed by a Consul Key/Value store using
+// the client you provide. This lets you share connections (along w/ your TLS, token, transport
+// and datacenter settings) with your service discovery and such. The Address, Username and
+// Password options are ignored since the client is already configured.
+func NewSourceFromClient(client *api.Client, opts ...configify.Option) (Source, error) {
+	if client == nil {
+		return nil, errors.New("consul source: missing client")
+	}
+	return NewSourceWithConfig(Config{Client: client}, opts...)
+}
+
+// NewSourceWithConfig creates a new config source backed by a Consul Key/Value store, letting
+// you tweak the Consul-specific settings (ACL tokens, TLS and such) that don't have a home in the
+// standard configify options.
+func NewSourceWithConfig(config Config, opts ...configify.Option) (Source, error) {
 	options := apply(opts, &configify.Options{
 		Defaults:        configify.Empty(),
 		RefreshInterval: 10 * time.Second,
@@ -21,36 +42,120 @@ func NewSource(opts ...configify.Option) (configify.SourceWatcher, error) {
 	if options.Context == nil {
 		return nil, errors.New("consul source: missing context option")
 	}
-	if options.Address == "" {
+	if config.Client == nil && options.Address == "" {
 		return nil, errors.New("consul source: missing address option")
 	}
-
-	client, err := api.NewClient(toConsulConfig(*options))
+	mapKey := config.KeyMapping
+	if mapKey == nil {
+		mapKey = identityMapping
+	}
+	schema, err := config.Schema.compile(configify.Massage{}, mapKey)
+	if err == nil {
+		schema, err = schema.withSecrets(config.Secrets, mapKey)
+	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "consul source: connect error")
+		return nil, errors.Wrap(err, "consul source: schema error")
 	}
-	source := consulSource{
-		client:  client,
-		kv:      client.KV(),
-		options: *options,
-		massage: configify.Massage{},
+
+	token, tokenFile := config.resolveToken()
+	if tokenFile != "" {
+		fileToken, err := readTokenFile(tokenFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "consul source: token file error")
+		}
+		if fileToken != "" {
+			token = fileToken
+		}
 	}
 
-	// start w/ a full set of values and then listen() to have periodic refreshes.
-	source.refresh()
-	return &source, source.listen()
-}
+	client := config.Client
+	if client == nil {
+		consulConfig, err := toConsulConfig(*options, config, token)
+		if err != nil {
+			return nil, errors.Wrapf(err, "consul source: config error")
+		}
+		if client, err = api.NewClient(consulConfig); err != nil {
+			return nil, errors.Wrapf(err, "consul source: connect error")
+		}
+	}
 
-func toConsulConfig(options configify.Options) *api.Config {
-	consulConfig := api.DefaultConfig()
-	consulConfig.Address = options.Address
-	if options.Username != "" || options.Password != "" {
-		consulConfig.HttpAuth = &api.HttpBasicAuth{
-			Username: options.Username,
-			Password: options.Password,
+	source := &consulSource{
+		client:     client,
+		kv:         client.KV(),
+		options:    *options,
+		massage:    configify.Massage{},
+		token:      token,
+		tokenFile:  tokenFile,
+		onError:    config.OnError,
+		schema:     schema,
+		strict:     config.Strict,
+		fallbacks:  config.Layers,
+		overrides:  config.Overrides,
+		flagPrefix: config.flagPrefix(namespaceDelimiter(*options), mapKey),
+		documents:  config.Documents,
+		mapKey:     mapKey,
+		validate:   config.Validate,
+	}
+	source.layers = source.buildLayers()
+	source.snapshot.Store(newSnapshot(0, nil, source.layers, namespaceDelimiter(*options), nil, mapKey))
+	if config.Debounce > 0 {
+		source.debouncer = &debouncer{
+			quiet:   config.Debounce,
+			maxWait: config.DebounceMaxWait,
+			notify:  source.notify,
 		}
 	}
-	return consulConfig
+
+	// start w/ a full set of values and then listen() for subsequent changes.
+	if err := source.load(config.Startup); err != nil && config.Startup.Required {
+		return nil, errors.Wrap(err, "consul source: initial load failed")
+	}
+	return source, source.listen()
+}
+
+// Source is a configify.SourceWatcher backed by Consul's Key/Value store. In addition to
+// the standard config lookups, it lets you keep an eye on the health of its connection.
+type Source interface {
+	configify.SourceWatcher
+	LookupSource
+	// LastError returns the error from the most recent attempt to refresh values from Consul.
+	// It is nil when that attempt succeeded. Non-nil errors are always a *RefreshError.
+	LastError() error
+	// LastSuccessfulRefresh is the last time that we heard back from Consul w/ a good answer. It
+	// is the zero time if we've never successfully loaded values.
+	LastSuccessfulRefresh() time.Time
+	// Snapshot returns a read-only source pinned to a single version (Consul index) of your
+	// namespace, so that multiple lookups are guaranteed to see consistent values.
+	Snapshot() configify.Source
+	// WatchChanges is like Watch, but your callback receives the individual keys that were
+	// added, modified or deleted. It returns a function you can call to stop watching.
+	WatchChanges(callback func(changes ChangeSet)) (unsubscribe func())
+	// WatchKey registers a callback that only fires when the given key changes.
+	WatchKey(key string, callback func(change KeyChange)) (unsubscribe func())
+	// WatchPrefix registers a callback that fires for each changed key that starts w/ the prefix.
+	WatchPrefix(prefix string, callback func(change KeyChange)) (unsubscribe func())
+	// Changes returns a channel of change events that closes when either your context or the
+	// source's context is done.
+	Changes(ctx context.Context, opts ...StreamOption) <-chan ChangeEvent
+	// Check tells you whether the key has a value that parses as the given type, distinguishing
+	// a missing key (ErrMissing) from a broken value (ErrMalformed or ErrOverflow).
+	Check(key string, fieldType FieldType) error
+	// Explain describes where the key's value comes from (Consul, your schema or the Defaults
+	// option) along w/ the Consul metadata for it.
+	Explain(key string) Explanation
+	// Keys returns every key (relative to your namespace) that currently has a value.
+	Keys() []string
+	// All returns the current value of every key, w/ secret values redacted so it's safe to log.
+	All() map[string]string
+	// Prefix is like All(), but only includes keys that start w/ the given prefix.
+	Prefix(prefix string) map[string]string
+	// Sub returns a source that only sees the keys beneath the given prefix, w/ the prefix
+	// stripped off. It shares this source's refresh loop.
+	Sub(prefix string) configify.SourceWatcher
+	// Flag returns the definition of the feature flag w/ the given name.
+	Flag(name string) (Flag, bool)
+	// FlagEnabled returns true when the feature flag is on for the given key (e.g. a user ID).
+	FlagEnabled(name string, key string) bool
 }
 
 func apply(options []configify.Option, defaults *configify.Options) *configify.Options {
@@ -60,215 +165,488 @@ func apply(options []configify.Option, defaults *configify.Options) *configify.O
 	return defaults
 }
 
+// consulSource is safe to read from any number of goroutines. The background refresh goroutine
+// is the only one that writes to lastIndex/rejected/nodeError/token/layers, and it publishes new
+// values by swapping in a brand new snapshot rather than modifying the current one, so lookups
+// never need to lock.
 type consulSource struct {
-	client    *api.Client
-	kv        *api.KV
-	options   configify.Options
-	massage   configify.Massage
-	values    map[string]string
-	lastIndex uint64
-	watcher   func(source configify.Source)
+	client       *api.Client
+	kv           *api.KV
+	options      configify.Options
+	massage      configify.Massage
+	snapshot     atomic.Value // always holds a *snapshot
+	lastIndex    uint64
+	rejected     *RefreshError
+	nodeError    *RefreshError
+	watcherMutex sync.RWMutex
+	watchers     []*watcher
+	debouncer    *debouncer
+	schema       *compiledSchema
+	strict       bool
+	fallbacks    []string
+	overrides    OverrideConfig
+	flagPrefix   string
+	documents    map[string]Decoder
+	mapKey       KeyMapping
+	layers       []string
+	validate     func(candidate configify.Source) error
+	token        string
+	tokenFile    string
+	onError      func(err error)
+	status       refreshStatus
 }
 
-func (c consulSource) Options() configify.Options {
+// refreshStatus tracks how our most recent attempts to refresh from Consul have gone. It's
+// updated by the background goroutine but read by anyone, so it's guarded by its own mutex.
+type refreshStatus struct {
+	mutex       sync.RWMutex
+	lastError   error
+	lastSuccess time.Time
+}
+
+func (c *consulSource) Options() configify.Options {
 	return c.options
 }
 
+// listen fires up a background goroutine that long-polls Consul for changes to our namespace. Rather
+// than re-reading the whole tree every so often, we issue blocking queries using the last index we've
+// seen, so Consul holds the request open until something actually changes (or RefreshInterval passes).
 func (c *consulSource) listen() error {
 	go func(source *consulSource) {
+		if source.debouncer != nil {
+			defer source.debouncer.stop()
+		}
 		for {
+			startIndex := source.lastIndex
+			startTime := time.Now()
+			err := source.refresh(source.blockingQuery)
+
 			select {
 			case <-source.options.Context.Done():
 				return
-			case <-time.After(c.Options().RefreshInterval):
-				break
+			default:
+			}
+
+			// If the request failed or the backend doesn't support blocking queries, we'll get an
+			// answer right away w/o any new data. Fall back to polling every RefreshInterval so that
+			// we don't hammer Consul in a tight loop. Values that we rejected (or an empty namespace)
+			// still came from a good answer, though, so keep long-polling to pick up the fix ASAP.
+			unchanged := source.lastIndex == startIndex
+			failed := err != nil && classify(err) != KindInvalid && classify(err) != KindNotFound
+			if failed || (unchanged && time.Since(startTime) < source.options.RefreshInterval/2) {
+				select {
+				case <-source.options.Context.Done():
+					return
+				case <-time.After(source.options.RefreshInterval):
+				}
 			}
-			// We do a refresh when we first set up the source, so don't fire off a second
-			// refresh until the first timeout.
-			source.refresh()
 		}
 	}(c)
 	return nil
 }
 
-func (c *consulSource) refresh() {
-	pairs, meta, err := c.kv.List(c.options.Namespace.Name, nil)
-	if err != nil {
-		return
-	}
-	// You already have the most up to date values
-	if meta.LastIndex <= c.lastIndex {
-		return
+// load performs the initial refresh when the source is created. When the policy says that
+// the values are required, we keep retrying until the policy's timeout has passed.
+func (c *consulSource) load(policy StartupPolicy) error {
+	if !policy.Required || policy.Timeout <= 0 {
+		return c.refresh(c.query)
 	}
 
-	// Convert the slice of pairs to a quick-to-lookup map
-	updatedValues := map[string]string{}
-	for _, pair := range pairs {
-		updatedValues[pair.Key] = string(pair.Value)
-	}
+	ctx, cancel := context.WithTimeout(c.options.Context, policy.Timeout)
+	defer cancel()
 
-	c.lastIndex = meta.LastIndex
-	c.values = updatedValues
+	backoff, maxBackoff := policy.backoff()
+	for {
+		err := c.refresh(func() *api.QueryOptions { return c.query().WithContext(ctx) })
+		if err == nil {
+			return nil
+		}
 
-	// You can't set up a watcher until we've done the initial refresh() in
-	// NewSource(), so this is guaranteed to only fire on subsequent auto-updates.
-	if c.watcher != nil {
-		c.watcher(c)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
 }
 
-func (c consulSource) lookup(key string) (string, bool) {
-	if value, ok := c.values[c.options.Namespace.Qualify(key)]; ok {
-		return strings.TrimSpace(value), true
-	}
-	return "", false
+// blockingQuery builds the query options that ask Consul to hold the request until the namespace
+// changes past our last known index. RefreshInterval is used as the max amount of time to wait.
+func (c *consulSource) blockingQuery() *api.QueryOptions {
+	query := c.query()
+	query.WaitIndex = c.lastIndex
+	query.WaitTime = c.options.RefreshInterval
+	return query
 }
 
-func (c *consulSource) Watch(callback func(source configify.Source)) {
-	c.watcher = callback
+// query builds the baseline options for all of our KV requests.
+func (c *consulSource) query() *api.QueryOptions {
+	query := &api.QueryOptions{Token: c.token}
+	return query.WithContext(c.options.Context)
 }
 
-func (c consulSource) String(key string) (string, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.String(key)
+// reloadToken re-reads the ACL token file (if we have one), so that when an operator rotates
+// the token we pick up the new one w/o a restart. Returns true when we've got a new token.
+func (c *consulSource) reloadToken() bool {
+	if c.tokenFile == "" {
+		return false
+	}
+	token, err := readTokenFile(c.tokenFile)
+	if err != nil || token == "" || token == c.token {
+		return false
 	}
-	return value, true
+	c.token = token
+	return true
 }
 
-func (c consulSource) StringSlice(key string) ([]string, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.StringSlice(key)
+// list fetches the pairs in every layer. When your namespace is the only prefix we need, that's
+// a single (possibly blocking) List. Otherwise, we block on every prefix at once until any of them
+// changes and then read all of them in a single transaction, so the snapshot sees every layer as
+// of the same moment rather than mixing versions from different indexes.
+func (c *consulSource) list(query *api.QueryOptions) (api.KVPairs, uint64, error) {
+	prefixes := c.prefixes()
+	if len(prefixes) == 1 {
+		pairs, meta, err := c.kv.List(prefixes[0], query)
+		if err != nil {
+			return nil, 0, err
+		}
+		return pairs, meta.LastIndex, nil
 	}
-	return c.massage.StringToSlice(value)
-}
 
-func (c consulSource) Int(key string) (int, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Int(key)
+	index, err := c.wait(prefixes, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	pairs, err := c.read(prefixes, query)
+	if err != nil {
+		return nil, 0, err
 	}
-	number, ok := c.massage.StringToInt64(value)
-	return int(number), ok
+	return pairs, index, nil
 }
 
-func (c consulSource) Int8(key string) (int8, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Int8(key)
+// prefixes are the prefixes that we have to fetch in order to see every layer. Override layers
+// live beneath your namespace, and a layer beneath another one (e.g. "svc/orders/prod" beneath
+// "svc/orders") comes along for free when we fetch its parent, so we skip those.
+func (c *consulSource) prefixes() []string {
+	delimiter := namespaceDelimiter(c.options)
+	candidates := append([]string{c.options.Namespace.Name}, c.fallbacks...)
+
+	var prefixes []string
+	for i, candidate := range candidates {
+		covered := false
+		for j, other := range candidates {
+			beneath := strings.HasPrefix(layerPrefix(candidate, delimiter), layerPrefix(other, delimiter))
+			if j != i && beneath && (candidate != other || j < i) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			prefixes = append(prefixes, candidate)
+		}
 	}
-	number, ok := c.massage.StringToInt64(value)
-	return int8(number), ok
+	return prefixes
 }
 
-func (c consulSource) Int16(key string) (int16, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Int16(key)
+// wait issues a blocking query for each prefix and returns as soon as any of them changes past
+// the query's WaitIndex. Consul's indexes are global, so the highest index we've seen works as
+// the WaitIndex for every prefix. When none of them changed (i.e. they all timed out), the index
+// is the highest one that came back. We only need the index, so we only ask for the keys.
+func (c *consulSource) wait(prefixes []string, query *api.QueryOptions) (uint64, error) {
+	ctx, cancel := context.WithCancel(query.Context())
+	defer cancel()
+
+	type result struct {
+		index uint64
+		err   error
+	}
+	results := make(chan result, len(prefixes))
+	for _, prefix := range prefixes {
+		go func(prefix string) {
+			_, meta, err := c.kv.Keys(prefix, "", query.WithContext(ctx))
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			results <- result{index: meta.LastIndex}
+		}(prefix)
 	}
-	number, ok := c.massage.StringToInt64(value)
-	return int16(number), ok
-}
 
-func (c consulSource) Int32(key string) (int32, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Int32(key)
+	var index uint64
+	for range prefixes {
+		result := <-results
+		if result.err != nil {
+			return 0, result.err
+		}
+		if query.WaitIndex > 0 && result.index > query.WaitIndex {
+			return result.index, nil
+		}
+		if result.index > index {
+			index = result.index
+		}
 	}
-	number, ok := c.massage.StringToInt64(value)
-	return int32(number), ok
+	return index, nil
 }
 
-func (c consulSource) Int64(key string) (int64, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Int64(key)
+// read fetches every pair beneath the prefixes in a single transaction so that they're all
+// consistent w/ each other.
+func (c *consulSource) read(prefixes []string, query *api.QueryOptions) (api.KVPairs, error) {
+	ops := make(api.TxnOps, len(prefixes))
+	for i, prefix := range prefixes {
+		ops[i] = &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVGetTree, Key: prefix}}
 	}
-	return c.massage.StringToInt64(value)
-}
 
-func (c consulSource) Uint(key string) (uint, bool) {
-	value, ok := c.lookup(key)
+	readQuery := &api.QueryOptions{Token: query.Token}
+	ok, response, _, err := c.client.Txn().Txn(ops, readQuery.WithContext(query.Context()))
+	if err != nil {
+		return nil, err
+	}
 	if !ok {
-		return c.options.Defaults.Uint(key)
+		var problems []string
+		for _, txnErr := range response.Errors {
+			problems = append(problems, txnErr.What)
+		}
+		return nil, errors.Errorf("transaction failed: %s", strings.Join(problems, "; "))
 	}
-	number, ok := c.massage.StringToUint64(value)
-	return uint(number), ok
-}
 
-func (c consulSource) Uint8(key string) (uint8, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Uint8(key)
+	var pairs api.KVPairs
+	for _, result := range response.Results {
+		if result.KV != nil {
+			pairs = append(pairs, result.KV)
+		}
 	}
-	number, ok := c.massage.StringToUint64(value)
-	return uint8(number), ok
+	return pairs, nil
 }
 
-func (c consulSource) Uint16(key string) (uint16, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Uint16(key)
+// refresh loads the latest values from Consul. We only build the query once we've (possibly)
+// discovered the node, since that means re-resolving every key rather than waiting on the index
+// from before.
+func (c *consulSource) refresh(buildQuery func() *api.QueryOptions) error {
+	// Missing the node's overrides is no reason to miss out on everything else, so let you know
+	// (via LastError, too) and carry on.
+	if err := c.discoverNode(); err != nil {
+		c.nodeError = newRefreshError(err, c.options.Namespace.Name)
+		_ = c.fail(c.nodeError)
+	} else {
+		c.nodeError = nil
 	}
-	number, ok := c.massage.StringToUint64(value)
-	return uint16(number), ok
-}
+	query := buildQuery()
+	pairs, index, err := c.list(query)
+	if classify(err) == KindPermission && c.reloadToken() {
+		query.Token = c.token
+		pairs, index, err = c.list(query)
+	}
+	if err != nil {
+		return c.fail(newRefreshError(err, c.options.Namespace.Name))
+	}
+	// You already have the most up to date values. We only check for equality because Consul's
+	// docs say that if the index ever goes backwards (e.g. a snapshot restore), you should treat
+	// the results as brand new data and start over from there. If we rejected this index, though,
+	// nothing has been fixed yet and we're still serving older values, so keep saying so.
+	if index == c.lastIndex {
+		if c.rejected != nil {
+			return c.fail(c.rejected)
+		}
+		return c.succeed(pairs)
+	}
+	c.lastIndex = index
+	c.rejected = nil
 
-func (c consulSource) Uint32(key string) (uint32, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Uint32(key)
+	// Convert the slice of pairs to a quick-to-lookup map
+	updated := newSnapshot(index, pairs, c.layers, namespaceDelimiter(c.options), c.hiddenPrefixes(), c.mapKey)
+	if c.flagPrefix != "" {
+		if updated.flags, err = parseFlags(updated, c.flagPrefix, c.massage); err != nil {
+			return c.reject(err)
+		}
 	}
-	number, ok := c.massage.StringToUint64(value)
-	return uint32(number), ok
-}
+	if updated.documents, err = parseDocuments(updated, c.documents); err != nil {
+		return c.reject(err)
+	}
+	// Make sure that the new values satisfy your schema (and that you're happy w/ them) before
+	// anyone else can see them.
+	if err := c.schema.validate(c.sourceFor(updated)); err != nil {
+		return c.reject(err)
+	}
+	if c.validate != nil {
+		if err := c.validate(c.sourceFor(updated)); err != nil {
+			return c.reject(err)
+		}
+	}
+	previous := c.current()
+	c.snapshot.Store(updated)
 
-func (c consulSource) Uint64(key string) (uint64, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Uint64(key)
+	// You can't set up a watcher until we've done the initial refresh() in
+	// NewSource(), so this is guaranteed to only fire on subsequent auto-updates. We
+	// also don't bother firing when something outside of our namespace changed or when
+	// someone re-wrote a key w/ the exact same value.
+	changes := diff(previous, updated)
+	if len(changes) > 0 {
+		c.deliver(ChangeSet{
+			Index:    updated.index,
+			Changes:  changes,
+			Source:   c.sourceFor(updated),
+			Previous: c.sourceFor(previous),
+		})
 	}
-	return c.massage.StringToUint64(value)
+	return c.succeed(pairs)
 }
 
-func (c consulSource) Float32(key string) (float32, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Float32(key)
+// succeed records that we heard back from Consul. An empty namespace still counts as a failure
+// since it means you're not getting any of the values you're expecting.
+func (c *consulSource) succeed(pairs api.KVPairs) error {
+	if len(pairs) == 0 {
+		return c.fail(&RefreshError{
+			Kind:      KindNotFound,
+			Namespace: c.options.Namespace.Name,
+			Err:       errors.New("no keys found in namespace"),
+		})
 	}
-	number, ok := c.massage.StringToFloat64(value)
-	return float32(number), ok
-}
 
-func (c consulSource) Float64(key string) (float64, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Float64(key)
+	c.status.mutex.Lock()
+	c.status.lastError = nil
+	if c.nodeError != nil {
+		// You're getting everything but the node's overrides, which isn't entirely healthy.
+		c.status.lastError = c.nodeError
 	}
-	return c.massage.StringToFloat64(value)
+	c.status.lastSuccess = time.Now()
+	c.status.mutex.Unlock()
+	return nil
 }
 
-func (c consulSource) Bool(key string) (bool, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Bool(key)
-	}
-	return c.massage.StringToBool(value)
+// reject records that the values at the current index are invalid so that we keep reporting it
+// until Consul gives us values that we can actually publish.
+func (c *consulSource) reject(err error) error {
+	c.rejected = &RefreshError{Kind: KindInvalid, Namespace: c.options.Namespace.Name, Err: err}
+	return c.fail(c.rejected)
 }
 
-func (c consulSource) Duration(key string) (time.Duration, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Duration(key)
+// fail records the refresh error and lets your error callback know about it. We don't bother
+// reporting errors once the context is done since those are just us shutting down.
+func (c *consulSource) fail(err *RefreshError) error {
+	if c.options.Context.Err() != nil {
+		return err
+	}
+
+	c.status.mutex.Lock()
+	c.status.lastError = err
+	c.status.mutex.Unlock()
+
+	if c.onError != nil {
+		c.onError(err)
 	}
-	return c.massage.StringToDuration(value)
+	return err
 }
 
-func (c consulSource) Time(key string) (time.Time, bool) {
-	value, ok := c.lookup(key)
-	if !ok {
-		return c.options.Defaults.Time(key)
+func (c *consulSource) LastError() error {
+	c.status.mutex.RLock()
+	defer c.status.mutex.RUnlock()
+	return c.status.lastError
+}
+
+func (c *consulSource) LastSuccessfulRefresh() time.Time {
+	c.status.mutex.RLock()
+	defer c.status.mutex.RUnlock()
+	return c.status.lastSuccess
+}
+
+// current returns the most recently published snapshot of the namespace's values.
+func (c *consulSource) current() *snapshot {
+	return c.snapshot.Load().(*snapshot)
+}
+
+// Snapshot returns a read-only source pinned to the values as they are right now. Subsequent
+// refreshes won't affect it, so you can read a bunch of related values (e.g. while binding a
+// struct) w/o worrying that a refresh lands between reading DB_HOST and DB_PORT.
+func (c *consulSource) Snapshot() configify.Source {
+	return c.pinned()
+}
+
+func (c *consulSource) pinned() snapshotSource {
+	return c.sourceFor(c.current())
+}
+
+// sourceFor wraps the snapshot so that you can use it as a standard configify.Source.
+func (c *consulSource) sourceFor(snap *snapshot) snapshotSource {
+	return snapshotSource{
+		snapshot: snap,
+		options:  c.options,
+		massage:  c.massage,
+		schema:   c.schema,
+		strict:   c.strict,
 	}
-	return c.massage.StringToTime(value)
+}
+
+func (c *consulSource) Check(key string, fieldType FieldType) error {
+	return c.pinned().Check(key, fieldType)
+}
+
+func (c *consulSource) String(key string) (string, bool) {
+	return c.pinned().String(key)
+}
+
+func (c *consulSource) StringSlice(key string) ([]string, bool) {
+	return c.pinned().StringSlice(key)
+}
+
+func (c *consulSource) Int(key string) (int, bool) {
+	return c.pinned().Int(key)
+}
+
+func (c *consulSource) Int8(key string) (int8, bool) {
+	return c.pinned().Int8(key)
+}
+
+func (c *consulSource) Int16(key string) (int16, bool) {
+	return c.pinned().Int16(key)
+}
+
+func (c *consulSource) Int32(key string) (int32, bool) {
+	return c.pinned().Int32(key)
+}
+
+func (c *consulSource) Int64(key string) (int64, bool) {
+	return c.pinned().Int64(key)
+}
+
+func (c *consulSource) Uint(key string) (uint, bool) {
+	return c.pinned().Uint(key)
+}
+
+func (c *consulSource) Uint8(key string) (uint8, bool) {
+	return c.pinned().Uint8(key)
+}
+
+func (c *consulSource) Uint16(key string) (uint16, bool) {
+	return c.pinned().Uint16(key)
+}
+
+func (c *consulSource) Uint32(key string) (uint32, bool) {
+	return c.pinned().Uint32(key)
+}
+
+func (c *consulSource) Uint64(key string) (uint64, bool) {
+	return c.pinned().Uint64(key)
+}
+
+func (c *consulSource) Float32(key string) (float32, bool) {
+	return c.pinned().Float32(key)
+}
+
+func (c *consulSource) Float64(key string) (float64, bool) {
+	return c.pinned().Float64(key)
+}
+
+func (c *consulSource) Bool(key string) (bool, bool) {
+	return c.pinned().Bool(key)
+}
+
+func (c *consulSource) Duration(key string) (time.Duration, bool) {
+	return c.pinned().Duration(key)
+}
+
+func (c *consulSource) Time(key string) (time.Time, bool) {
+	return c.pinned().Time(key)
 }
@@ -2,11 +2,32 @@ package consul_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
 	"github.com/robsignorelli/configify"
 	"github.com/robsignorelli/configify-consul"
 	"github.com/robsignorelli/configify/configifytest"
@@ -101,6 +122,220 @@ func (suite *ConsulSuite) TestFactoryValidation() {
 	suite.NoError(err, "should not return an error when supplying bad credentials")
 }
 
+// TestNewSourceFromClient makes sure that we can reuse an existing Consul client rather
+// than having the source build its own connection.
+func (suite *ConsulSuite) TestNewSourceFromClient() {
+	_, err := consul.NewSourceFromClient(nil,
+		configify.Context(suite.context))
+	suite.Error(err, "should return an error: no client")
+
+	_, err = consul.NewSourceFromClient(suite.client)
+	suite.Error(err, "should return an error: no context")
+
+	source, err := consul.NewSourceFromClient(suite.client,
+		configify.Context(suite.context),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err, "should not return an error with a valid client")
+
+	value, ok := source.String("HTTP_HOST")
+	suite.Equal("foo.example.com", value)
+	suite.True(ok)
+}
+
+// TestToken makes sure that we send the ACL token you configured, falling back to the
+// standard Consul environment variable when you don't supply one explicitly.
+func (suite *ConsulSuite) TestToken() {
+	server := newACLServer("s3cr3t")
+	defer server.Close()
+
+	source, err := consul.NewSourceWithConfig(consul.Config{Token: "s3cr3t"},
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	value, _ := source.String("HTTP_HOST")
+	suite.Equal("acl.example.com", value)
+
+	source, err = consul.NewSourceWithConfig(consul.Config{Token: "wrong"},
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	_, ok := source.String("HTTP_HOST")
+	suite.False(ok, "should not load values with the wrong token")
+
+	_ = os.Setenv(api.HTTPTokenEnvName, "s3cr3t")
+	defer os.Unsetenv(api.HTTPTokenEnvName)
+	source, err = consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	value, _ = source.String("HTTP_HOST")
+	suite.Equal("acl.example.com", value)
+
+	// Your own client's token wins over the env variables.
+	_ = os.Setenv(api.HTTPTokenEnvName, "wrong")
+	_ = os.Setenv(api.HTTPTokenFileEnvName, "/does/not/exist")
+	defer os.Unsetenv(api.HTTPTokenFileEnvName)
+	client, err := api.NewClient(&api.Config{Address: server.URL, Token: "s3cr3t"})
+	suite.Require().NoError(err)
+	source, err = consul.NewSourceFromClient(client,
+		configify.Context(suite.context),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err, "should ignore the env token file")
+	value, _ = source.String("HTTP_HOST")
+	suite.Equal("acl.example.com", value, "should use the client's token")
+}
+
+// TestTokenFile makes sure that we re-read the token file when Consul rejects our token
+// so that rotated tokens get picked up w/o restarting.
+func (suite *ConsulSuite) TestTokenFile() {
+	server := newACLServer("new-token")
+	defer server.Close()
+
+	dir := suite.tempDir()
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	suite.Require().NoError(ioutil.WriteFile(tokenFile, []byte("old-token\n"), 0600))
+
+	_, err := consul.NewSourceWithConfig(consul.Config{TokenFile: filepath.Join(dir, "nope")},
+		configify.Context(suite.context),
+		configify.Address(server.URL))
+	suite.Error(err, "should return an error: missing token file")
+
+	source, err := consul.NewSourceWithConfig(consul.Config{TokenFile: tokenFile},
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(50*time.Millisecond))
+	suite.Require().NoError(err)
+	_, ok := source.String("HTTP_HOST")
+	suite.False(ok, "should not load values with the old token")
+
+	// Rotate the token and give the source a couple of refresh cycles to notice.
+	suite.Require().NoError(ioutil.WriteFile(tokenFile, []byte("new-token\n"), 0600))
+	time.Sleep(500 * time.Millisecond)
+	value, _ := source.String("HTTP_HOST")
+	suite.Equal("acl.example.com", value)
+}
+
+// TestTLS makes sure that we can talk to an HTTPS agent signed by a private CA.
+func (suite *ConsulSuite) TestTLS() {
+	server := httptest.NewTLSServer(kvHandler("tls.example.com"))
+	defer server.Close()
+
+	dir := suite.tempDir()
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	suite.writePEM(caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	source, err := consul.NewSourceWithConfig(consul.Config{TLS: consul.TLSConfig{CAFile: caFile}},
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	value, _ := source.String("HTTP_HOST")
+	suite.Equal("tls.example.com", value)
+
+	// Same thing, but pointing at a directory full of CAs rather than a single file.
+	source, err = consul.NewSourceWithConfig(consul.Config{TLS: consul.TLSConfig{CAPath: dir}},
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	value, _ = source.String("HTTP_HOST")
+	suite.Equal("tls.example.com", value)
+
+	source, err = consul.NewSourceWithConfig(consul.Config{TLS: consul.TLSConfig{InsecureSkipVerify: true}},
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	value, _ = source.String("HTTP_HOST")
+	suite.Equal("tls.example.com", value)
+
+	// The system CAs have no idea who signed the test server's certificate.
+	source, err = consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	_, ok := source.String("HTTP_HOST")
+	suite.False(ok, "should not trust an unknown CA")
+
+	// A certificate that's valid but for a different host should also fail.
+	source, err = consul.NewSourceWithConfig(consul.Config{TLS: consul.TLSConfig{CAFile: caFile, ServerName: "consul.example.org"}},
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	_, ok = source.String("HTTP_HOST")
+	suite.False(ok, "should not trust a certificate for a different host")
+
+	_, err = consul.NewSourceWithConfig(consul.Config{TLS: consul.TLSConfig{CAFile: filepath.Join(dir, "nope.pem")}},
+		configify.Context(suite.context),
+		configify.Address(server.URL))
+	suite.Error(err, "should return an error: missing CA file")
+
+	_, err = consul.NewSourceWithConfig(consul.Config{TLS: consul.TLSConfig{CertFile: caFile}},
+		configify.Context(suite.context),
+		configify.Address(server.URL))
+	suite.Error(err, "should return an error: cert w/o a key")
+}
+
+// TestMutualTLS makes sure that we present our client certificate and that we pick up
+// rotated certificates from disk w/o having to restart.
+func (suite *ConsulSuite) TestMutualTLS() {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.PeerCertificates) == 0 || req.TLS.PeerCertificates[0].Subject.CommonName != "client-2" {
+			// Hang up so the client performs a fresh handshake on its next attempt.
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		kvHandler("mtls.example.com").ServeHTTP(w, req)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := suite.tempDir()
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	suite.writePEM(caFile, "CERTIFICATE", server.Certificate().Raw)
+	suite.writeClientCert(certFile, keyFile, "client-1")
+
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{TLS: consul.TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}},
+		configify.Context(suite.context),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(50*time.Millisecond))
+	suite.Require().NoError(err)
+	_, ok := source.String("HTTP_HOST")
+	suite.False(ok, "should not load values with the wrong client certificate")
+
+	suite.writeClientCert(certFile, keyFile, "client-2")
+	time.Sleep(500 * time.Millisecond)
+	value, _ := source.String("HTTP_HOST")
+	suite.Equal("mtls.example.com", value)
+}
+
 // TestWatcher makes sure that your registered watcher fires when a value is updated
 // in the backend consul KV store.
 func (suite *ConsulSuite) TestWatcher() {
@@ -127,37 +362,469 @@ func (suite *ConsulSuite) TestWatcher() {
 	wg.Wait()
 }
 
-// TestRefreshDelay verifies that updates to the backend Consul store are not immediate, but
-// happen after the configured refresh interval.
-func (suite *ConsulSuite) TestRefreshDelay() {
+// TestMultipleWatchers makes sure that registering another watcher doesn't replace the first,
+// that you can unsubscribe, and that a panicking watcher doesn't spoil it for the others.
+func (suite *ConsulSuite) TestMultipleWatchers() {
+	panics := make(chan error, 10)
+	source, _ := consul.NewSourceWithConfig(
+		consul.Config{OnError: func(err error) { panics <- err }},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+
+	first := make(chan string, 10)
+	second := make(chan string, 10)
+	unsubscribed := make(chan string, 10)
+	source.WatchChanges(func(changes consul.ChangeSet) {
+		panic("oh no!")
+	})
+	source.Watch(func(updated configify.Source) {
+		value, _ := updated.String("HTTP_HOST")
+		first <- value
+	})
+	source.Watch(func(updated configify.Source) {
+		value, _ := updated.String("HTTP_HOST")
+		second <- value
+	})
+	unsubscribe := source.WatchChanges(func(changes consul.ChangeSet) {
+		unsubscribed <- changes.Changes[0].NewValue
+	})
+	unsubscribe()
+
+	suite.set("FOO/HTTP_HOST", "google.com")
+	expect := func(watcher chan string) {
+		select {
+		case value := <-watcher:
+			suite.Equal("google.com", value)
+		case <-time.After(2 * time.Second):
+			suite.Fail("watcher should have fired")
+		}
+	}
+	expect(first)
+	expect(second)
+
+	select {
+	case err := <-panics:
+		suite.Equal(consul.KindWatcher, err.(*consul.RefreshError).Kind)
+	case <-time.After(2 * time.Second):
+		suite.Fail("watcher panic should have been reported")
+	}
+	suite.Empty(unsubscribed, "unsubscribed watcher should not fire")
+
+	// The panic shouldn't have killed the refresh goroutine.
+	suite.set("FOO/HTTP_HOST", "bing.com")
+	time.Sleep(300 * time.Millisecond)
+	value, _ := source.String("HTTP_HOST")
+	suite.Equal("bing.com", value)
+}
+
+// TestDebounce makes sure that a burst of writes results in a single notification once
+// things have quieted down.
+func (suite *ConsulSuite) TestDebounce() {
+	source, _ := consul.NewSourceWithConfig(
+		consul.Config{Debounce: 300 * time.Millisecond},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+
+	changeSets := make(chan consul.ChangeSet, 10)
+	source.WatchChanges(func(changes consul.ChangeSet) {
+		changeSets <- changes
+	})
+
+	for i := 0; i < 5; i++ {
+		suite.set("FOO/KEY_"+strconv.Itoa(i), strconv.Itoa(i))
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Values show up right away even though the notification is delayed.
+	value, _ := source.Int("KEY_4")
+	suite.Equal(4, value)
+	suite.Empty(changeSets, "should wait for things to quiet down")
+
+	select {
+	case changes := <-changeSets:
+		suite.Len(changes.Changes, 5)
+	case <-time.After(2 * time.Second):
+		suite.Fail("watcher should have fired once things quieted down")
+	}
+	time.Sleep(500 * time.Millisecond)
+	suite.Empty(changeSets, "should only fire once for the whole burst")
+}
+
+// TestDebounceMaxWait makes sure that a steady trickle of writes doesn't hold off
+// notifications forever.
+func (suite *ConsulSuite) TestDebounceMaxWait() {
+	source, _ := consul.NewSourceWithConfig(
+		consul.Config{Debounce: 300 * time.Millisecond, DebounceMaxWait: 500 * time.Millisecond},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+
+	start := time.Now()
+	changeSets := make(chan consul.ChangeSet, 10)
+	elapsed := make(chan time.Duration, 10)
+	source.WatchChanges(func(changes consul.ChangeSet) {
+		elapsed <- time.Since(start)
+		changeSets <- changes
+	})
+
+	for i := 0; i < 10; i++ {
+		suite.set("FOO/KEY_"+strconv.Itoa(i), strconv.Itoa(i))
+		time.Sleep(100 * time.Millisecond)
+	}
+	select {
+	case changes := <-changeSets:
+		suite.True(<-elapsed < 900*time.Millisecond, "should not wait longer than the max")
+		suite.True(len(changes.Changes) < 10, "should not have waited for the whole trickle")
+	case <-time.After(2 * time.Second):
+		suite.Fail("watcher should have fired")
+	}
+}
+
+// TestWatchChanges makes sure that change watchers receive the individual keys that were
+// added, modified or deleted, and that they don't fire when nothing really changed.
+func (suite *ConsulSuite) TestWatchChanges() {
 	source, _ := consul.NewSource(
 		configify.Context(suite.context),
 		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+
+	changeSets := make(chan consul.ChangeSet, 10)
+	source.WatchChanges(func(changes consul.ChangeSet) {
+		changeSets <- changes
+	})
+	expectChange := func(expected consul.Change) {
+		select {
+		case changes := <-changeSets:
+			suite.Require().Len(changes.Changes, 1)
+			actual := changes.Changes[0]
+			suite.Equal(expected.Key, actual.Key)
+			suite.Equal(expected.QualifiedKey, actual.QualifiedKey)
+			suite.Equal(expected.Type, actual.Type)
+			suite.Equal(expected.OldValue, actual.OldValue)
+			suite.Equal(expected.NewValue, actual.NewValue)
+			suite.Equal(expected.OldIndex == 0, actual.OldIndex == 0)
+			suite.Equal(expected.NewIndex == 0, actual.NewIndex == 0)
+			suite.True(changes.Index >= actual.NewIndex)
+
+			value, _ := changes.Source.String(expected.Key)
+			suite.Equal(expected.NewValue, value)
+		case <-time.After(2 * time.Second):
+			suite.Fail("watcher should have fired for " + expected.Key)
+		}
+	}
+	expectNoChange := func() {
+		select {
+		case changes := <-changeSets:
+			suite.Fail("watcher should not have fired", "%+v", changes.Changes)
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+
+	suite.set("FOO/HTTP_HOST", "google.com")
+	expectChange(consul.Change{
+		Key:          "HTTP_HOST",
+		QualifiedKey: "FOO/HTTP_HOST",
+		Type:         consul.KeyModified,
+		OldValue:     "foo.example.com",
+		NewValue:     "google.com",
+		OldIndex:     1,
+		NewIndex:     1,
+	})
+
+	suite.set("FOO/NEW_KEY", "new")
+	expectChange(consul.Change{
+		Key:          "NEW_KEY",
+		QualifiedKey: "FOO/NEW_KEY",
+		Type:         consul.KeyAdded,
+		NewValue:     "new",
+		NewIndex:     1,
+	})
+
+	_, err := suite.kv.Delete("FOO/FLOAT", nil)
+	suite.Require().NoError(err)
+	expectChange(consul.Change{
+		Key:          "FLOAT",
+		QualifiedKey: "FOO/FLOAT",
+		Type:         consul.KeyDeleted,
+		OldValue:     "12.345",
+		OldIndex:     1,
+	})
+
+	// Re-writing the same value or changing a key that's not really in our namespace
+	// shouldn't bother the watcher at all.
+	suite.set("FOO/HTTP_HOST", "google.com")
+	expectNoChange()
+	suite.set("FOOD/HTTP_HOST", "food.example.com")
+	expectNoChange()
+}
+
+// TestWatchKeyAndPrefix makes sure that key/prefix watchers only fire for the keys they
+// care about and that they can read the old and new values as whatever type they need.
+func (suite *ConsulSuite) TestWatchKeyAndPrefix() {
+	source, _ := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+
+	portChanges := make(chan consul.KeyChange, 10)
+	featureChanges := make(chan consul.KeyChange, 10)
+	source.WatchKey("HTTP_PORT", func(change consul.KeyChange) {
+		portChanges <- change
+	})
+	source.WatchPrefix("FEATURE/", func(change consul.KeyChange) {
+		featureChanges <- change
+	})
+
+	suite.set("FOO/HTTP_HOST", "google.com")
+	suite.set("FOO/HTTP_PORT", "5678")
+	select {
+	case change := <-portChanges:
+		suite.Equal("HTTP_PORT", change.Key)
+		oldPort, _ := change.Old.Int16(change.Key)
+		newPort, _ := change.New.Int16(change.Key)
+		suite.Equal(int16(1234), oldPort)
+		suite.Equal(int16(5678), newPort)
+	case <-time.After(2 * time.Second):
+		suite.Fail("key watcher should have fired")
+	}
+
+	suite.set("FOO/FEATURE/DARK_MODE", "true")
+	select {
+	case change := <-featureChanges:
+		suite.Equal("FEATURE/DARK_MODE", change.Key)
+		suite.Equal(consul.KeyAdded, change.Type)
+		_, existed := change.Old.Bool(change.Key)
+		enabled, _ := change.New.Bool(change.Key)
+		suite.False(existed)
+		suite.True(enabled)
+	case <-time.After(2 * time.Second):
+		suite.Fail("prefix watcher should have fired")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	suite.Empty(portChanges, "key watcher should only fire for its key")
+	suite.Empty(featureChanges, "prefix watcher should only fire for its prefix")
+}
+
+// TestChanges makes sure that the change stream delivers changes and closes when your
+// context is done.
+func (suite *ConsulSuite) TestChanges() {
+	source, _ := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := source.Changes(ctx)
+
+	suite.set("FOO/HTTP_HOST", "google.com")
+	select {
+	case event := <-changes:
+		suite.Equal(1, event.Coalesced)
+		suite.Require().Len(event.Changes, 1)
+		suite.Equal("HTTP_HOST", event.Changes[0].Key)
+		suite.Equal("google.com", event.Changes[0].NewValue)
+	case <-time.After(2 * time.Second):
+		suite.Fail("stream should have received the change")
+	}
+
+	cancel()
+	select {
+	case _, open := <-changes:
+		suite.False(open, "stream should close when the context is done")
+	case <-time.After(2 * time.Second):
+		suite.Fail("stream should close when the context is done")
+	}
+}
+
+// TestChangesCoalesce makes sure that a slow reader gets the changes it missed merged
+// together rather than blocking the source.
+func (suite *ConsulSuite) TestChangesCoalesce() {
+	ctx, cancel := context.WithCancel(suite.context)
+	source, _ := consul.NewSource(
+		configify.Context(ctx),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
 		configify.RefreshInterval(1*time.Second))
+	changes := source.Changes(context.Background(), consul.StreamBuffer(1))
+
+	// The first change is waiting to be read. The rest pile up in the buffer.
+	suite.set("FOO/HTTP_HOST", "google.com")
+	time.Sleep(200 * time.Millisecond)
+	suite.set("FOO/HTTP_PORT", "5678")
+	time.Sleep(200 * time.Millisecond)
+	suite.set("FOO/HTTP_HOST", "bing.com")
+	time.Sleep(200 * time.Millisecond)
+	suite.set("FOO/NEW_KEY", "new")
+	time.Sleep(200 * time.Millisecond)
+	_, _ = suite.kv.Delete("FOO/NEW_KEY", nil)
+	time.Sleep(200 * time.Millisecond)
+
+	event := <-changes
+	suite.Equal(1, event.Coalesced)
+	suite.Require().Len(event.Changes, 1)
+	suite.Equal("google.com", event.Changes[0].NewValue)
+
+	event = <-changes
+	suite.Equal(4, event.Coalesced)
+	suite.Require().Len(event.Changes, 2, "added-then-deleted keys should drop out")
+	suite.Equal("HTTP_HOST", event.Changes[0].Key)
+	suite.Equal(consul.KeyModified, event.Changes[0].Type)
+	suite.Equal("google.com", event.Changes[0].OldValue)
+	suite.Equal("bing.com", event.Changes[0].NewValue)
+	suite.Equal("HTTP_PORT", event.Changes[1].Key)
+	suite.Equal("1234", event.Changes[1].OldValue)
+	suite.Equal("5678", event.Changes[1].NewValue)
+
+	// The stream should also close when the source's context is done.
+	cancel()
+	_, open := <-changes
+	suite.False(open)
+}
+
+// TestChangesBlock makes sure that the Block overflow policy delivers every change set
+// individually, even when the reader falls behind.
+func (suite *ConsulSuite) TestChangesBlock() {
+	source, _ := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+	changes := source.Changes(suite.context,
+		consul.StreamBuffer(1),
+		consul.StreamOverflow(consul.Block))
+
+	hosts := []string{"google.com", "bing.com", "yahoo.com"}
+	for _, host := range hosts {
+		suite.set("FOO/HTTP_HOST", host)
+		time.Sleep(200 * time.Millisecond)
+	}
+	for _, host := range hosts {
+		event := <-changes
+		suite.Equal(1, event.Coalesced)
+		suite.Require().Len(event.Changes, 1)
+		suite.Equal(host, event.Changes[0].NewValue)
+	}
+}
+
+// TestBlockingRefresh verifies that updates to the backend Consul store show up right away rather
+// than waiting for the refresh interval to elapse since we're using blocking queries.
+func (suite *ConsulSuite) TestBlockingRefresh() {
+	source, _ := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.RefreshInterval(5*time.Second))
 
 	// Read the initial value then change it in Consul
 	value, _ := source.String("FOO/HTTP_HOST")
 	suite.Equal("foo.example.com", value)
 	suite.set("FOO/HTTP_HOST", "google.com")
 
-	// Our updates are not immediate. It will take at least the "RefreshInterval" to
-	// realize the new value for the key.
-	value, _ = source.String("FOO/HTTP_HOST")
-	suite.Equal("foo.example.com", value)
-
-	// Now that another refresh cycle has occurred, the new value is available.
-	time.Sleep(2 * time.Second)
+	// The blocking query should return as soon as Consul sees the update, so we should
+	// have the new value well before the 5 second refresh interval.
+	time.Sleep(500 * time.Millisecond)
 	value, _ = source.String("FOO/HTTP_HOST")
 	suite.Equal("google.com", value)
 
 	// Since we didn't change it, the next refresh cycle should be the same value.
-	time.Sleep(2 * time.Second)
+	time.Sleep(1 * time.Second)
 	value, _ = source.String("FOO/HTTP_HOST")
 	suite.Equal("google.com", value)
 }
 
+// TestSnapshot makes sure that a snapshot keeps serving the same values even after the
+// source has refreshed w/ new ones.
+func (suite *ConsulSuite) TestSnapshot() {
+	source, _ := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+
+	snapshot := source.Snapshot()
+	suite.Equal(source.Options(), snapshot.Options())
+
+	suite.set("FOO/HTTP_HOST", "google.com")
+	suite.set("FOO/HTTP_PORT", "5678")
+	time.Sleep(500 * time.Millisecond)
+
+	host, _ := snapshot.String("HTTP_HOST")
+	port, _ := snapshot.Int16("HTTP_PORT")
+	suite.Equal("foo.example.com", host)
+	suite.Equal(int16(1234), port)
+
+	host, _ = source.String("HTTP_HOST")
+	port, _ = source.Int16("HTTP_PORT")
+	suite.Equal("google.com", host)
+	suite.Equal(int16(5678), port)
+
+	host, _ = source.Snapshot().String("HTTP_HOST")
+	suite.Equal("google.com", host)
+}
+
+// TestConcurrentReads hammers the source w/ reads while values are being refreshed in the
+// background. Run w/ -race (like `make test` does) to make sure it's actually safe.
+func (suite *ConsulSuite) TestConcurrentReads() {
+	source, _ := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+	source.Watch(func(updated configify.Source) {
+		_, _ = updated.String("HTTP_HOST")
+	})
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_, _ = source.String("HTTP_HOST")
+					_, _ = source.Int16("HTTP_PORT")
+					_, _ = source.Duration("DURATION_1")
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		suite.set("FOO/HTTP_PORT", strconv.Itoa(8000+i))
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	port, _ := source.Int16("HTTP_PORT")
+	suite.Equal(int16(8019), port)
+}
+
 // TestRefreshFailure ensures that we can still create a valid store even if we can't
-// establish a real connection to Consul. You get blank values
+// establish a real connection to Consul. You get blank values unless you require the
+// initial load to succeed (see TestStartupRequired).
 func (suite *ConsulSuite) TestRefreshFailure() {
 	// The consul.NewClient() function only barfs if it can't recognize the protocol. It
 	// doesn't do anything if the host is bad, unfortunately.
@@ -175,6 +842,1042 @@ func (suite *ConsulSuite) TestRefreshFailure() {
 	suite.False(ok)
 }
 
+// TestRefreshErrors makes sure that we report failed refreshes rather than silently swallowing
+// them, and that we classify them so you can decide how loudly to complain.
+func (suite *ConsulSuite) TestRefreshErrors() {
+	expectError := func(address string, namespace string, kind consul.ErrorKind) {
+		mutex := sync.Mutex{}
+		var reported []error
+		onError := func(err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			reported = append(reported, err)
+		}
+		source, err := consul.NewSourceWithConfig(
+			consul.Config{OnError: onError},
+			configify.Context(suite.context),
+			configify.Address(address),
+			configify.Namespace(namespace),
+			configify.NamespaceDelim("/"),
+			configify.RefreshInterval(1*time.Second))
+		suite.Require().NoError(err)
+
+		refreshErr, ok := source.LastError().(*consul.RefreshError)
+		suite.Require().True(ok, "should report a *RefreshError")
+		suite.Equal(kind, refreshErr.Kind)
+		suite.Equal(namespace, refreshErr.Namespace)
+		suite.True(source.LastSuccessfulRefresh().IsZero())
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		suite.Require().NotEmpty(reported, "should call OnError")
+		suite.Equal(kind, reported[0].(*consul.RefreshError).Kind)
+	}
+
+	server := newACLServer("s3cr3t")
+	defer server.Close()
+	expectError(server.URL, "FOO", consul.KindPermission)
+
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		_, _ = w.Write([]byte("{not json"))
+	}))
+	defer garbage.Close()
+	expectError(garbage.URL, "FOO", consul.KindDecode)
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("No cluster leader"))
+	}))
+	defer broken.Close()
+	expectError(broken.URL, "FOO", consul.KindConnection)
+
+	closed := httptest.NewServer(kvHandler("closed.example.com"))
+	closed.Close()
+	expectError(closed.URL, "FOO", consul.KindConnection)
+
+	expectError(consulTestEndpoint, "NOT_A_NAMESPACE", consul.KindNotFound)
+}
+
+// TestRefreshErrorRecovery makes sure that we clear the last error once Consul starts behaving.
+func (suite *ConsulSuite) TestRefreshErrorRecovery() {
+	source, err := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("LATER"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+	suite.Require().NoError(err)
+	suite.Error(source.LastError())
+	suite.True(source.LastSuccessfulRefresh().IsZero())
+
+	suite.set("LATER/HTTP_HOST", "later.example.com")
+	time.Sleep(500 * time.Millisecond)
+	suite.NoError(source.LastError())
+	suite.False(source.LastSuccessfulRefresh().IsZero())
+
+	value, _ := source.String("HTTP_HOST")
+	suite.Equal("later.example.com", value)
+}
+
+// TestEmptyNamespace makes sure that deleting every key in the namespace is reported as an error,
+// but that we still stop serving the deleted values.
+func (suite *ConsulSuite) TestEmptyNamespace() {
+	suite.set("LATER/HTTP_HOST", "later.example.com")
+	source, err := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("LATER"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(1*time.Second))
+	suite.Require().NoError(err)
+	suite.NoError(source.LastError())
+
+	changes := make(chan consul.KeyChange, 10)
+	source.WatchKey("HTTP_HOST", func(change consul.KeyChange) {
+		changes <- change
+	})
+	_, err = suite.kv.Delete("LATER/HTTP_HOST", nil)
+	suite.Require().NoError(err)
+	select {
+	case change := <-changes:
+		suite.Equal(consul.KeyDeleted, change.Type)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should notice that the last key was deleted")
+	}
+
+	_, ok := source.String("HTTP_HOST")
+	suite.False(ok, "should stop serving deleted values")
+	refreshErr, ok := source.LastError().(*consul.RefreshError)
+	suite.Require().True(ok, "should report the empty namespace")
+	suite.Equal(consul.KindNotFound, refreshErr.Kind)
+}
+
+// TestStartupRequired makes sure that you can refuse to start up w/o config when Consul
+// isn't able to give us our initial values.
+func (suite *ConsulSuite) TestStartupRequired() {
+	start := time.Now()
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{Startup: consul.StartupPolicy{Required: true, Timeout: 300 * time.Millisecond}},
+		configify.Context(suite.context),
+		configify.Address("asldjfaslkdjf"),
+		configify.RefreshInterval(1*time.Second))
+	suite.Error(err, "should return an error: unable to load initial values")
+	suite.Nil(source)
+	suite.True(time.Since(start) >= 300*time.Millisecond, "should retry until the timeout")
+
+	refreshErr, ok := errors.Cause(err).(*consul.RefreshError)
+	suite.Require().True(ok, "should wrap the *RefreshError")
+	suite.Equal(consul.KindConnection, refreshErr.Kind)
+
+	// No timeout means that we only try once.
+	_, err = consul.NewSourceWithConfig(
+		consul.Config{Startup: consul.StartupPolicy{Required: true}},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("LATER"),
+		configify.NamespaceDelim("/"))
+	suite.Error(err, "should return an error: empty namespace")
+
+	// Keep retrying until the values show up.
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		suite.set("LATER/HTTP_HOST", "later.example.com")
+	}()
+	source, err = consul.NewSourceWithConfig(
+		consul.Config{Startup: consul.StartupPolicy{Required: true, Timeout: 5 * time.Second, Backoff: 50 * time.Millisecond}},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("LATER"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err, "should retry until the values show up")
+	value, _ := source.String("HTTP_HOST")
+	suite.Equal("later.example.com", value)
+}
+
+// TestValidate makes sure that we keep serving the last good values when your validator
+// rejects new ones, and that we pick up the next good values once they're fixed.
+func (suite *ConsulSuite) TestValidate() {
+	errs := make(chan error, 10)
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			OnError: func(err error) { errs <- err },
+			Validate: func(candidate configify.Source) error {
+				if _, ok := candidate.Int16("HTTP_PORT"); !ok {
+					return errors.New("HTTP_PORT must be a number")
+				}
+				return nil
+			},
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(200*time.Millisecond))
+	suite.Require().NoError(err)
+
+	watched := make(chan configify.Source, 10)
+	source.Watch(func(updated configify.Source) {
+		watched <- updated
+	})
+
+	suite.set("FOO/HTTP_PORT", "12a4")
+	select {
+	case err := <-errs:
+		suite.Equal(consul.KindInvalid, err.(*consul.RefreshError).Kind)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should report the rejected values")
+	}
+	suite.Error(source.LastError())
+	lastSuccess := source.LastSuccessfulRefresh()
+
+	// We should still be serving the last good values, and watchers shouldn't hear about it. Even
+	// once the blocking query times out w/ nothing new, the bad values are still there.
+	time.Sleep(500 * time.Millisecond)
+	value, _ := source.String("HTTP_PORT")
+	suite.Equal("1234", value)
+	suite.Empty(watched)
+	suite.Error(source.LastError(), "should keep reporting the rejected values")
+	suite.Equal(lastSuccess, source.LastSuccessfulRefresh())
+
+	suite.set("FOO/HTTP_PORT", "4321")
+	select {
+	case updated := <-watched:
+		port, _ := updated.Int16("HTTP_PORT")
+		suite.Equal(int16(4321), port)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should pick up the good values")
+	}
+	suite.NoError(source.LastError())
+}
+
+// TestValidateRecovery makes sure that we pick up fixed values right away rather than waiting
+// for RefreshInterval after rejecting bad ones.
+func (suite *ConsulSuite) TestValidateRecovery() {
+	errs := make(chan error, 10)
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			OnError: func(err error) { errs <- err },
+			Validate: func(candidate configify.Source) error {
+				if _, ok := candidate.Int16("HTTP_PORT"); !ok {
+					return errors.New("HTTP_PORT must be a number")
+				}
+				return nil
+			},
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(10*time.Second))
+	suite.Require().NoError(err)
+
+	suite.set("FOO/HTTP_PORT", "12a4")
+	select {
+	case <-errs:
+	case <-time.After(2 * time.Second):
+		suite.Fail("should report the rejected values")
+	}
+
+	suite.set("FOO/HTTP_PORT", "4321")
+	time.Sleep(500 * time.Millisecond)
+	port, _ := source.Int16("HTTP_PORT")
+	suite.Equal(int16(4321), port, "should not back off after rejecting values")
+	suite.NoError(source.LastError())
+}
+
+// TestSchema makes sure that we reject values that don't satisfy your schema and that we fall
+// back to the schema's defaults when keys are missing.
+func (suite *ConsulSuite) TestSchema() {
+	schema := consul.Schema{
+		{Key: "HTTP_HOST", Type: consul.TypeString, Required: true, Pattern: `^[a-z.]+$`},
+		{Key: "HTTP_PORT", Type: consul.TypeUint16, Required: true, Description: "port we listen on"},
+		{Key: "FLOAT", Type: consul.TypeFloat64, Range: &consul.Range{Min: 0, Max: 100}},
+		{Key: "TIMEOUT", Type: consul.TypeDuration, Default: "30s"},
+		{Key: "RETRIES", Type: consul.TypeInt8, Default: "3"},
+	}
+
+	errs := make(chan error, 10)
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			OnError: func(err error) { errs <- err },
+			Schema:  schema,
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(200*time.Millisecond))
+	suite.Require().NoError(err)
+	suite.NoError(source.LastError())
+
+	timeout, ok := source.Duration("TIMEOUT")
+	suite.True(ok, "should fall back to the schema default")
+	suite.Equal(30*time.Second, timeout)
+	retries, _ := source.Int8("RETRIES")
+	suite.Equal(int8(3), retries)
+	port, _ := source.Uint16("HTTP_PORT")
+	suite.Equal(uint16(1234), port)
+
+	// Overflowing a uint16 and breaking the range should both be reported at once.
+	suite.set("FOO/HTTP_PORT", "70000")
+	suite.set("FOO/FLOAT", "123.4")
+	var refreshErr *consul.RefreshError
+	deadline := time.After(2 * time.Second)
+	for refreshErr == nil {
+		select {
+		case err := <-errs:
+			refreshErr = err.(*consul.RefreshError)
+			schemaErr, ok := refreshErr.Err.(*consul.SchemaError)
+			if !ok || len(schemaErr.Problems) < 2 {
+				refreshErr = nil // caught it between the two writes
+				continue
+			}
+			suite.Equal(consul.KindInvalid, refreshErr.Kind)
+			suite.Equal("HTTP_PORT", schemaErr.Problems[0].Field.Key)
+			suite.Equal("70000", schemaErr.Problems[0].Value)
+			suite.Contains(schemaErr.Error(), "port we listen on")
+			suite.Equal("FLOAT", schemaErr.Problems[1].Field.Key)
+		case <-deadline:
+			suite.FailNow("should report the schema violations")
+		}
+	}
+	port, _ = source.Uint16("HTTP_PORT")
+	suite.Equal(uint16(1234), port, "should keep serving the last valid values")
+
+	// Once a required key goes away, the whole update is rejected.
+	suite.set("FOO/HTTP_PORT", "8080")
+	suite.set("FOO/FLOAT", "1.5")
+	_, err = suite.kv.Delete("FOO/HTTP_HOST", nil)
+	suite.Require().NoError(err)
+	time.Sleep(500 * time.Millisecond)
+	suite.Error(source.LastError())
+	host, _ := source.String("HTTP_HOST")
+	suite.Equal("foo.example.com", host)
+
+	suite.set("FOO/HTTP_HOST", "bar.example.com")
+	time.Sleep(500 * time.Millisecond)
+	suite.NoError(source.LastError())
+	port, _ = source.Uint16("HTTP_PORT")
+	suite.Equal(uint16(8080), port)
+}
+
+// TestSchemaValidation makes sure that you find out about a broken schema right away.
+func (suite *ConsulSuite) TestSchemaValidation() {
+	_, err := consul.NewSourceWithConfig(
+		consul.Config{Schema: consul.Schema{{Key: "HTTP_HOST", Pattern: "[a-z"}}},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint))
+	suite.Error(err, "should reject a bad pattern")
+
+	_, err = consul.NewSourceWithConfig(
+		consul.Config{Schema: consul.Schema{{Key: "RETRIES", Type: consul.TypeInt8, Default: "300"}}},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint))
+	suite.Error(err, "should reject a default that doesn't fit the type")
+
+	_, err = consul.NewSourceWithConfig(
+		consul.Config{
+			Schema:  consul.Schema{{Key: "MISSING", Required: true}},
+			Startup: consul.StartupPolicy{Required: true},
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Error(err, "should fail a required startup when values violate the schema")
+}
+
+// TestStrict makes sure that strict parsing refuses to wrap values that don't fit in the type
+// and that Check() tells a missing key apart from a broken value.
+func (suite *ConsulSuite) TestStrict() {
+	suite.set("FOO/BIG", "70000")
+	suite.set("FOO/NEGATIVE", "-1")
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{Strict: true},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+
+	_, ok := source.Int16("BIG")
+	suite.False(ok, "should not wrap 70000 into an int16")
+	_, ok = source.Uint8("NEGATIVE")
+	suite.False(ok, "should not wrap -1 into a uint8")
+	big, ok := source.Int32("BIG")
+	suite.True(ok)
+	suite.Equal(int32(70000), big)
+	port, ok := source.Int16("HTTP_PORT")
+	suite.True(ok)
+	suite.Equal(int16(1234), port)
+
+	// The default, lenient mode keeps the old wrap-around behavior.
+	lenient, err := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	wrapped, ok := lenient.Int16("BIG")
+	suite.True(ok)
+	suite.Equal(int16(4464), wrapped)
+
+	suite.NoError(source.Check("HTTP_PORT", consul.TypeUint16))
+	err = source.Check("NOPE", consul.TypeInt)
+	suite.Equal(consul.ErrMissing, err.(*consul.LookupError).Err)
+	err = source.Check("HTTP_HOST", consul.TypeInt)
+	suite.Equal(consul.ErrMalformed, err.(*consul.LookupError).Err)
+	err = source.Check("BIG", consul.TypeInt16)
+	suite.Equal(consul.ErrOverflow, err.(*consul.LookupError).Err)
+	suite.Equal("70000", err.(*consul.LookupError).Value)
+}
+
+// TestLookupErrors makes sure that the error-returning getters tell you exactly what went wrong.
+func (suite *ConsulSuite) TestLookupErrors() {
+	suite.set("FOO/HTTP_PORT", "70000")
+	source, err := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.Defaults(configify.Values{"TIMEOUT": 5 * time.Second, "RETRIES": "lots", "WORKERS": "70000"}))
+	suite.Require().NoError(err)
+
+	host, err := source.StringE("HTTP_HOST")
+	suite.NoError(err)
+	suite.Equal("foo.example.com", host)
+	timeout, err := source.DurationE("TIMEOUT")
+	suite.NoError(err, "should fall back to defaults")
+	suite.Equal(5*time.Second, timeout)
+
+	_, err = source.Int16E("HTTP_PORT")
+	lookupErr := err.(*consul.LookupError)
+	suite.Equal(consul.ErrOverflow, lookupErr.Err)
+	suite.Equal("FOO/HTTP_PORT", lookupErr.QualifiedKey)
+	suite.Equal("70000", lookupErr.Value)
+	suite.Equal(consul.TypeInt16, lookupErr.Type)
+	suite.NotZero(lookupErr.ModifyIndex)
+	suite.False(lookupErr.DefaultApplied)
+	suite.Contains(err.Error(), "FOO/HTTP_PORT")
+
+	_, err = source.BoolE("HTTP_HOST")
+	suite.Equal(consul.ErrMalformed, err.(*consul.LookupError).Err)
+
+	_, err = source.IntE("RETRIES")
+	lookupErr = err.(*consul.LookupError)
+	suite.Equal(consul.ErrMalformed, lookupErr.Err)
+	suite.Equal("lots", lookupErr.Value)
+	suite.True(lookupErr.DefaultApplied)
+	suite.Zero(lookupErr.ModifyIndex)
+
+	_, err = source.Int16E("WORKERS")
+	lookupErr = err.(*consul.LookupError)
+	suite.Equal(consul.ErrOverflow, lookupErr.Err, "should classify defaults like any other value")
+	suite.Equal("70000", lookupErr.Value)
+	suite.True(lookupErr.DefaultApplied)
+
+	_, err = source.TimeE("NOPE")
+	lookupErr = err.(*consul.LookupError)
+	suite.Equal(consul.ErrMissing, lookupErr.Err)
+	suite.Equal("FOO/NOPE", lookupErr.QualifiedKey)
+	suite.False(lookupErr.DefaultApplied)
+}
+
+// TestExplain makes sure that we can tell you which layer supplied a value.
+func (suite *ConsulSuite) TestExplain() {
+	_, err := suite.kv.Put(&api.KVPair{Key: "FOO/FLAGGED", Value: []byte("hi"), Flags: 42}, nil)
+	suite.Require().NoError(err)
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{Schema: consul.Schema{{Key: "TIMEOUT", Type: consul.TypeDuration, Default: "30s"}}},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.Defaults(configify.Values{"RETRIES": "3"}))
+	suite.Require().NoError(err)
+
+	explanation := source.Explain("FLAGGED")
+	suite.True(explanation.Found)
+	suite.Equal(consul.OriginConsul, explanation.Origin)
+	suite.Equal("FOO/FLAGGED", explanation.QualifiedKey)
+	suite.Equal([]byte("hi"), explanation.Value)
+	suite.Equal(uint64(42), explanation.Flags)
+	suite.NotZero(explanation.ModifyIndex)
+	suite.True(explanation.LastIndex >= explanation.ModifyIndex)
+
+	explanation = source.Explain("TIMEOUT")
+	suite.False(explanation.Found)
+	suite.Equal(consul.OriginSchema, explanation.Origin)
+	suite.Equal([]byte("30s"), explanation.Value)
+
+	explanation = source.Explain("RETRIES")
+	suite.False(explanation.Found)
+	suite.Equal(consul.OriginDefaults, explanation.Origin)
+	suite.Equal([]byte("3"), explanation.Value)
+	suite.Zero(explanation.ModifyIndex)
+
+	explanation = source.Explain("NOPE")
+	suite.False(explanation.Found)
+	suite.Equal(consul.OriginNone, explanation.Origin)
+	suite.Empty(explanation.Value)
+}
+
+// TestKeysAndAll makes sure that you can dump your effective config w/o leaking secrets.
+func (suite *ConsulSuite) TestKeysAndAll() {
+	suite.set("FOO/DB_HOST", "db.example.com")
+	suite.set("FOO/DB_PASSWORD", "hunter2")
+	suite.set("FOO/API_KEY", "abc123")
+	suite.set("FOOD/NOT_MINE", "nope")
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			Secrets: []string{"*_PASSWORD"},
+			Schema: consul.Schema{
+				{Key: "API_KEY", Secret: true},
+				{Key: "DB_PORT", Type: consul.TypeUint16, Default: "5432"},
+			},
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+
+	keys := source.Keys()
+	suite.Contains(keys, "HTTP_HOST")
+	suite.Contains(keys, "DB_PORT", "should include schema defaults")
+	suite.NotContains(keys, "NOT_MINE")
+	suite.NotContains(keys, "D/NOT_MINE")
+	suite.True(sort.StringsAreSorted(keys))
+
+	all := source.All()
+	suite.Len(all, len(keys))
+	suite.Equal("foo.example.com", all["HTTP_HOST"])
+	suite.Equal(consul.Redacted, all["DB_PASSWORD"])
+	suite.Equal(consul.Redacted, all["API_KEY"])
+
+	password, _ := source.String("DB_PASSWORD")
+	suite.Equal("hunter2", password, "lookups should still see the real value")
+
+	suite.Equal(map[string]string{
+		"DB_HOST":     "db.example.com",
+		"DB_PASSWORD": consul.Redacted,
+		"DB_PORT":     "5432",
+	}, source.Prefix("DB_"))
+
+	_, err = consul.NewSourceWithConfig(
+		consul.Config{Secrets: []string{"[oops"}},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint))
+	suite.Error(err, "should reject bad secret patterns")
+}
+
+// TestSub makes sure that a sub-source only sees (and only hears about) the keys beneath it.
+func (suite *ConsulSuite) TestSub() {
+	suite.set("FOO/db/HOST", "db.example.com")
+	suite.set("FOO/db/PORT", "5432")
+	suite.set("FOO/cache/HOST", "cache.example.com")
+	source, err := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(50*time.Millisecond))
+	suite.Require().NoError(err)
+
+	db := source.Sub("db")
+	suite.Equal("FOO/db", db.Options().Namespace.Name)
+	host, _ := db.String("HOST")
+	suite.Equal("db.example.com", host)
+	port, _ := db.Uint16("PORT")
+	suite.Equal(uint16(5432), port)
+	_, ok := db.String("HTTP_HOST")
+	suite.False(ok, "should not see keys outside of the sub-source")
+
+	watched := make(chan configify.Source, 10)
+	db.Watch(func(updated configify.Source) {
+		watched <- updated
+	})
+
+	suite.set("FOO/cache/HOST", "redis.example.com")
+	suite.set("FOO/HTTP_HOST", "google.com")
+	time.Sleep(300 * time.Millisecond)
+	suite.Empty(watched, "should ignore changes outside of the sub-source")
+
+	suite.set("FOO/db/HOST", "postgres.example.com")
+	select {
+	case updated := <-watched:
+		host, _ := updated.String("HOST")
+		suite.Equal("postgres.example.com", host)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should hear about changes beneath the sub-source")
+	}
+	host, _ = db.String("HOST")
+	suite.Equal("postgres.example.com", host)
+
+	// W/o a delimiter, we join the sub-source's name and keys w/ "_" just like configify.
+	suite.set("BAZ_db_HOST", "baz-db.example.com")
+	undelimited, err := consul.NewSource(
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("BAZ"),
+		configify.RefreshInterval(50*time.Millisecond))
+	suite.Require().NoError(err)
+	undelimitedDB := undelimited.Sub("db")
+	host, _ = undelimitedDB.String("HOST")
+	suite.Equal("baz-db.example.com", host)
+
+	undelimitedDB.Watch(func(updated configify.Source) {
+		watched <- updated
+	})
+	suite.set("BAZ_db_HOST", "baz-postgres.example.com")
+	select {
+	case updated := <-watched:
+		host, _ := updated.String("HOST")
+		suite.Equal("baz-postgres.example.com", host)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should hear about changes beneath the undelimited sub-source")
+	}
+}
+
+// TestLayers makes sure that keys resolve to the most specific layer that has them.
+func (suite *ConsulSuite) TestLayers() {
+	suite.set("global/TIMEOUT", "30s")
+	suite.set("global/RETRIES", "3")
+	suite.set("global/REGION", "us-east-1")
+	suite.set("svc/orders/RETRIES", "5")
+	suite.set("svc/orders/prod/RETRIES", "10")
+	suite.set("svc/orders/prod/HOST", "orders.example.com")
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{Layers: []string{"svc/orders", "global"}},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("svc/orders/prod"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(10*time.Second))
+	suite.Require().NoError(err)
+
+	retries, _ := source.Int("RETRIES")
+	suite.Equal(10, retries)
+	timeout, _ := source.Duration("TIMEOUT")
+	suite.Equal(30*time.Second, timeout)
+	host, _ := source.String("HOST")
+	suite.Equal("orders.example.com", host)
+	suite.Contains(source.Keys(), "REGION")
+	suite.NotContains(source.Keys(), "prod/RETRIES", "nested layers should only supply their own keys")
+	_, ok := source.String("prod/RETRIES")
+	suite.False(ok)
+
+	explanation := source.Explain("TIMEOUT")
+	suite.Equal("global", explanation.Layer)
+	suite.Equal("global/TIMEOUT", explanation.QualifiedKey)
+	suite.Equal([]string{"svc/orders/prod/TIMEOUT", "svc/orders/TIMEOUT", "global/TIMEOUT"}, explanation.Searched)
+	suite.Equal("svc/orders/prod", source.Explain("RETRIES").Layer)
+
+	changes := make(chan consul.KeyChange, 10)
+	source.WatchKey("RETRIES", func(change consul.KeyChange) {
+		changes <- change
+	})
+
+	// Removing the override should fall back to the next layer down.
+	_, err = suite.kv.Delete("svc/orders/prod/RETRIES", nil)
+	suite.Require().NoError(err)
+	select {
+	case change := <-changes:
+		suite.Equal(consul.KeyModified, change.Type)
+		suite.Equal("10", change.OldValue)
+		suite.Equal("5", change.NewValue)
+		suite.Equal("svc/orders/RETRIES", change.QualifiedKey)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should fall back to the next layer")
+	}
+
+	// Changes to a lower layer are picked up right away, too (not just once the namespace's
+	// blocking query times out).
+	suite.set("global/TIMEOUT", "1m")
+	time.Sleep(500 * time.Millisecond)
+	timeout, _ = source.Duration("TIMEOUT")
+	suite.Equal(time.Minute, timeout)
+}
+
+// TestDefaultDelimiter makes sure that we join namespaces, layers and keys w/ "_" when you don't
+// set a delimiter, just like configify does.
+func (suite *ConsulSuite) TestDefaultDelimiter() {
+	suite.set("BAZ_HTTP_HOST", "baz.example.com")
+	suite.set("BAZ_HTTP_PORT", "80")
+	suite.set("BAZ_overrides_tag_canary_HTTP_PORT", "8080")
+	suite.set("GLOBAL_TIMEOUT", "30s")
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			Layers:    []string{"GLOBAL"},
+			Overrides: consul.OverrideConfig{Tags: []string{"canary"}},
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("BAZ"))
+	suite.Require().NoError(err)
+
+	host, ok := source.String("HTTP_HOST")
+	suite.True(ok)
+	suite.Equal("baz.example.com", host)
+	port, _ := source.Int("HTTP_PORT")
+	suite.Equal(8080, port, "should find the overrides")
+	timeout, _ := source.Duration("TIMEOUT")
+	suite.Equal(30*time.Second, timeout, "should find the layers")
+	suite.Equal([]string{"HTTP_HOST", "HTTP_PORT", "TIMEOUT"}, source.Keys())
+	suite.Equal("BAZ_HTTP_HOST", source.Explain("HTTP_HOST").QualifiedKey)
+}
+
+// TestOverrides makes sure that node and tag overrides win over the regular keys.
+func (suite *ConsulSuite) TestOverrides() {
+	nodeName, err := suite.client.Agent().NodeName()
+	suite.Require().NoError(err)
+	suite.set("FOO/overrides/node/"+nodeName+"/HTTP_PORT", "9999")
+	suite.set("FOO/overrides/node/some-other-node/HTTP_PORT", "1")
+	suite.set("FOO/overrides/tag/canary/HTTP_PORT", "2")
+	suite.set("FOO/overrides/tag/canary/HTTP_HOST", "canary.example.com")
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			Overrides: consul.OverrideConfig{Node: true, Tags: []string{"canary"}},
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(100*time.Millisecond))
+	suite.Require().NoError(err)
+	suite.NoError(source.LastError())
+
+	port, _ := source.Int("HTTP_PORT")
+	suite.Equal(9999, port, "node overrides should win")
+	host, _ := source.String("HTTP_HOST")
+	suite.Equal("canary.example.com", host, "tag overrides should win over the regular key")
+	float, _ := source.Float64("FLOAT")
+	suite.Equal(12.345, float)
+	suite.Equal("FOO/overrides/node/"+nodeName, source.Explain("HTTP_PORT").Layer)
+	for _, key := range source.Keys() {
+		suite.NotContains(key, "overrides", "should hide the overrides from the regular keys")
+	}
+
+	// Removing an override falls back to the next one down, and adding one takes over.
+	_, err = suite.kv.Delete("FOO/overrides/node/"+nodeName+"/HTTP_PORT", nil)
+	suite.Require().NoError(err)
+	suite.set("FOO/overrides/tag/canary/FLOAT", "1.5")
+	time.Sleep(500 * time.Millisecond)
+	port, _ = source.Int("HTTP_PORT")
+	suite.Equal(2, port)
+	float, _ = source.Float64("FLOAT")
+	suite.Equal(1.5, float)
+
+	explicit, err := consul.NewSourceWithConfig(
+		consul.Config{
+			Overrides: consul.OverrideConfig{Node: true, NodeName: "some-other-node"},
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	port, _ = explicit.Int("HTTP_PORT")
+	suite.Equal(1, port)
+}
+
+// TestOverridesWithoutAgent makes sure that we still load config when we can't discover the node
+// name, and that we pick up the node's overrides once we can.
+func (suite *ConsulSuite) TestOverridesWithoutAgent() {
+	nodeName, err := suite.client.Agent().NodeName()
+	suite.Require().NoError(err)
+	suite.set("FOO/overrides/node/"+nodeName+"/HTTP_PORT", "9999")
+
+	target, err := url.Parse("http://" + consulTestEndpoint)
+	suite.Require().NoError(err)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	denied := int32(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v1/agent/self" && atomic.LoadInt32(&denied) == 1 {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("Permission denied"))
+			return
+		}
+		proxy.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+	// Stop the source before the proxy, otherwise closing it waits on our blocking query.
+	ctx, cancel := context.WithCancel(suite.context)
+	defer cancel()
+
+	errs := make(chan error, 100)
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			OnError:   func(err error) { errs <- err },
+			Overrides: consul.OverrideConfig{Node: true},
+		},
+		configify.Context(ctx),
+		configify.Address(server.URL),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(10*time.Second))
+	suite.Require().NoError(err)
+
+	select {
+	case err := <-errs:
+		suite.Contains(err.Error(), "unable to discover node name")
+	default:
+		suite.Fail("should report that we couldn't discover the node name")
+	}
+	suite.Error(source.LastError(), "should keep reporting that we couldn't discover the node name")
+	port, _ := source.Int("HTTP_PORT")
+	suite.Equal(1234, port, "should load everything but the node's overrides")
+
+	// Any change wakes us up, and then we shouldn't wait for another one to pick up the overrides.
+	atomic.StoreInt32(&denied, 0)
+	suite.set("FOO/HTTP_HOST", "woken.example.com")
+	time.Sleep(500 * time.Millisecond)
+	port, _ = source.Int("HTTP_PORT")
+	suite.Equal(9999, port, "should keep trying to discover the node name")
+	suite.NoError(source.LastError())
+}
+
+// TestFeatureFlags makes sure that flags are evaluated consistently and hot-reloaded.
+func (suite *ConsulSuite) TestFeatureFlags() {
+	suite.set("FOO/flags/SIMPLE", "true")
+	suite.set("FOO/flags/OFF", "false")
+	suite.set("FOO/flags/ROLLOUT", `{"enabled": true, "percentage": 50}`)
+	suite.set("FOO/flags/VIP", `{"enabled": true, "allow": ["user-1"]}`)
+	suite.set("FOO/flags/LATER", `{"enabled": true, "start": "2999-01-01T00:00:00Z"}`)
+	errs := make(chan error, 10)
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			OnError:    func(err error) { errs <- err },
+			FlagPrefix: "flags",
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(100*time.Millisecond))
+	suite.Require().NoError(err)
+
+	suite.True(source.FlagEnabled("SIMPLE", "anyone"))
+	suite.False(source.FlagEnabled("OFF", "anyone"))
+	suite.False(source.FlagEnabled("NOPE", "anyone"))
+	suite.True(source.FlagEnabled("VIP", "user-1"))
+	suite.False(source.FlagEnabled("VIP", "user-2"))
+	suite.False(source.FlagEnabled("LATER", "user-1"))
+
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		user := "user-" + strconv.Itoa(i)
+		if source.FlagEnabled("ROLLOUT", user) {
+			enabled++
+		}
+		suite.Equal(source.FlagEnabled("ROLLOUT", user), source.FlagEnabled("ROLLOUT", user))
+	}
+	suite.True(enabled > 400 && enabled < 600, "should roll out to about half: %d", enabled)
+
+	flag, ok := source.Flag("LATER")
+	suite.True(ok)
+	suite.True(flag.Active("user-1", time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	// Broken definitions are rejected and we keep using the old ones.
+	suite.set("FOO/flags/OFF", `{"enabled": tru`)
+	select {
+	case err := <-errs:
+		suite.Equal(consul.KindInvalid, err.(*consul.RefreshError).Kind)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should reject the broken flag")
+	}
+	suite.False(source.FlagEnabled("OFF", "anyone"))
+
+	suite.set("FOO/flags/OFF", "true")
+	time.Sleep(300 * time.Millisecond)
+	suite.True(source.FlagEnabled("OFF", "anyone"), "should hot-reload flags")
+}
+
+// TestFlagsDisabled makes sure that keys which happen to look like flags are just regular values
+// unless you've asked for feature flags.
+func (suite *ConsulSuite) TestFlagsDisabled() {
+	suite.set("FOO/flags/LIST", "a,b")
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"))
+	suite.Require().NoError(err)
+	suite.NoError(source.LastError())
+
+	value, _ := source.String("flags/LIST")
+	suite.Equal("a,b", value)
+	port, _ := source.Int("HTTP_PORT")
+	suite.Equal(1234, port)
+	suite.False(source.FlagEnabled("LIST", "anyone"))
+}
+
+// TestDocuments makes sure that you can read (and watch) values inside of structured documents.
+func (suite *ConsulSuite) TestDocuments() {
+	suite.set("FOO/config.json", `{
+		"db": {"host": "db.example.com", "port": 5432},
+		"servers": [{"host": "a"}, {"host": "b"}],
+		"labels": ["x", "y"],
+		"timeout": "5s",
+		"big": 1234567890123
+	}`)
+	suite.set("FOO/app.conf", "color=blue\nsize=10")
+	suite.set("FOO/config.yaml", "db:\n  host: yaml.example.com\n  port: 5433\nservers:\n  - host: a\n  - host: b\n")
+	suite.set("FOO/config.hcl", "db {\n  host = \"hcl.example.com\"\n  port = 5434\n}\nlabels = [\"x\", \"y\"]\n")
+	lines := func(data []byte) (interface{}, error) {
+		document := map[string]interface{}{}
+		for _, line := range strings.Split(string(data), "\n") {
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) != 2 {
+				return nil, errors.New("bad line: " + line)
+			}
+			document[parts[0]] = parts[1]
+		}
+		return document, nil
+	}
+
+	errs := make(chan error, 10)
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			OnError: func(err error) { errs <- err },
+			Documents: map[string]consul.Decoder{
+				"config.json": nil,
+				"app.conf":    lines,
+				"config.yaml": consul.DecodeYAML,
+				"config.hcl":  consul.DecodeHCL,
+			},
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(100*time.Millisecond))
+	suite.Require().NoError(err)
+
+	host, _ := source.String("config.json#db.host")
+	suite.Equal("db.example.com", host)
+	port, _ := source.Uint16("config.json#db.port")
+	suite.Equal(uint16(5432), port)
+	server, _ := source.String("config.json#servers.1.host")
+	suite.Equal("b", server)
+	labels, _ := source.StringSlice("config.json#labels")
+	suite.Equal([]string{"x", "y"}, labels)
+	timeout, _ := source.Duration("config.json#timeout")
+	suite.Equal(5*time.Second, timeout)
+	big, _ := source.Uint64("config.json#big")
+	suite.Equal(uint64(1234567890123), big)
+	size, _ := source.Int("app.conf#size")
+	suite.Equal(10, size)
+	_, ok := source.String("config.json#db.nope")
+	suite.False(ok)
+
+	host, _ = source.String("config.yaml#db.host")
+	suite.Equal("yaml.example.com", host)
+	port, _ = source.Uint16("config.yaml#db.port")
+	suite.Equal(uint16(5433), port)
+	server, _ = source.String("config.yaml#servers.1.host")
+	suite.Equal("b", server)
+	db, _ := source.String("config.yaml#db")
+	suite.JSONEq(`{"host": "yaml.example.com", "port": 5433}`, db, "YAML maps should come back as JSON")
+	host, _ = source.String("config.hcl#db.host")
+	suite.Equal("hcl.example.com", host)
+	port, _ = source.Uint16("config.hcl#db.port")
+	suite.Equal(uint16(5434), port)
+	labels, _ = source.StringSlice("config.hcl#labels")
+	suite.Equal([]string{"x", "y"}, labels)
+
+	explanation := source.Explain("config.json#db.port")
+	suite.Equal("FOO/config.json#db.port", explanation.QualifiedKey)
+	suite.Equal([]byte("5432"), explanation.Value)
+
+	changes := make(chan consul.KeyChange, 10)
+	source.WatchKey("config.json#db.port", func(change consul.KeyChange) {
+		changes <- change
+	})
+	suite.set("FOO/config.json", `{"db": {"host": "db.example.com", "port": 6543}}`)
+	select {
+	case change := <-changes:
+		suite.Equal(consul.KeyModified, change.Type)
+		suite.Equal("5432", change.OldValue)
+		suite.Equal("6543", change.NewValue)
+		port, _ := change.New.Int("config.json#db.port")
+		suite.Equal(6543, port)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should notice changes inside of the document")
+	}
+
+	// Broken documents are rejected like any other bad value.
+	suite.set("FOO/config.json", `{"db": {`)
+	select {
+	case err := <-errs:
+		suite.Equal(consul.KindInvalid, err.(*consul.RefreshError).Kind)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should reject the broken document")
+	}
+	port, _ = source.Uint16("config.json#db.port")
+	suite.Equal(uint16(6543), port)
+}
+
+// TestKeyMapping makes sure that you can ask for flat keys when Consul stores hierarchical ones.
+func (suite *ConsulSuite) TestKeyMapping() {
+	suite.set("FOO/http/host", "localhost")
+	suite.set("FOO/http/port", "8080")
+	suite.set("FOO/db/password", "hunter2")
+	suite.set("FOO/vault/token", "s.abc123")
+	suite.set("FOO/API_KEY", "xyz")
+
+	source, err := consul.NewSourceWithConfig(
+		consul.Config{
+			KeyMapping: consul.ChainKeyMappings(consul.SlashToUnderscore, consul.CaseInsensitive),
+			Schema: consul.Schema{
+				{Key: "http_timeout", Type: consul.TypeDuration, Default: "5s"},
+				{Key: "db/password", Type: consul.TypeString, Secret: true},
+			},
+			Secrets: []string{"*/token", "api_key"},
+		},
+		configify.Context(suite.context),
+		configify.Address(consulTestEndpoint),
+		configify.Namespace("FOO"),
+		configify.NamespaceDelim("/"),
+		configify.RefreshInterval(100*time.Millisecond))
+	suite.Require().NoError(err)
+
+	host, ok := source.String("HTTP_HOST")
+	suite.True(ok)
+	suite.Equal("localhost", host)
+	port, _ := source.Int("http_port")
+	suite.Equal(8080, port)
+	port, _ = source.Int("http/port")
+	suite.Equal(8080, port)
+	timeout, _ := source.Duration("HTTP_TIMEOUT")
+	suite.Equal(5*time.Second, timeout)
+
+	suite.Subset(source.Keys(), []string{"DB_PASSWORD", "HTTP_HOST", "HTTP_PORT", "HTTP_TIMEOUT"})
+	suite.NotContains(source.Keys(), "http/host")
+	suite.Equal(map[string]string{"DB_PASSWORD": consul.Redacted}, source.Prefix("db_"))
+	all := source.All()
+	suite.Equal(consul.Redacted, all["VAULT_TOKEN"], "secret patterns should go through the key mapping")
+	suite.Equal(consul.Redacted, all["API_KEY"])
+	suite.Equal("localhost", all["HTTP_HOST"])
+	suite.Equal("FOO/http/host", source.Explain("http_host").QualifiedKey)
+
+	changes := make(chan consul.KeyChange, 10)
+	source.WatchKey("http_port", func(change consul.KeyChange) {
+		changes <- change
+	})
+	suite.set("FOO/http/port", "9090")
+	select {
+	case change := <-changes:
+		suite.Equal("HTTP_PORT", change.Key)
+		suite.Equal("FOO/http/port", change.QualifiedKey)
+		suite.Equal("9090", change.NewValue)
+	case <-time.After(2 * time.Second):
+		suite.Fail("should notice changes to mapped keys")
+	}
+}
+
 // TestCancelContext ensures that we stop listening for updates in Consul when the
 // underlying context has expired.
 func (suite *ConsulSuite) TestCancelContext() {
@@ -429,3 +2132,61 @@ func (suite *ConsulSuite) TestTime() {
 	suite.ExpectTime("NO_NAMESPACE_STRING", time.Time{}, false)
 	suite.ExpectTime("ASDF", time.Time{}, false)
 }
+
+func (suite *ConsulSuite) tempDir() string {
+	dir, err := ioutil.TempDir("", "configify-consul")
+	suite.Require().NoError(err)
+	return dir
+}
+
+func (suite *ConsulSuite) writePEM(path string, blockType string, data []byte) {
+	// Write then rename so that readers never see a half-written file (like real rotations).
+	encoded := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data})
+	suite.Require().NoError(ioutil.WriteFile(path+".tmp", encoded, 0600))
+	suite.Require().NoError(os.Rename(path+".tmp", path))
+}
+
+// writeClientCert generates a self-signed client certificate w/ the given common name.
+func (suite *ConsulSuite) writeClientCert(certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Require().NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	suite.Require().NoError(err)
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	suite.Require().NoError(err)
+
+	suite.writePEM(keyFile, "EC PRIVATE KEY", keyBytes)
+	suite.writePEM(certFile, "CERTIFICATE", cert)
+}
+
+// kvHandler behaves like a Consul agent w/ a single key "FOO/HTTP_HOST" in its KV store.
+func kvHandler(httpHost string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		_ = json.NewEncoder(w).Encode(api.KVPairs{
+			{Key: "FOO/HTTP_HOST", Value: []byte(httpHost), ModifyIndex: 1},
+		})
+	})
+}
+
+// newACLServer fires up a fake Consul agent w/ ACLs enabled. It only serves the namespace
+// "FOO" to requests that include the given token.
+func newACLServer(token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Consul-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("Permission denied"))
+			return
+		}
+		kvHandler("acl.example.com").ServeHTTP(w, req)
+	}))
+}
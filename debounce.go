@@ -0,0 +1,74 @@
+package consul
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer holds onto change sets until the namespace has been quiet for a while, merging them
+// together so that watchers hear about a burst of writes once rather than once per write.
+type debouncer struct {
+	quiet      time.Duration
+	maxWait    time.Duration
+	notify     func(changes ChangeSet)
+	mutex      sync.Mutex
+	pending    *ChangeSet
+	first      time.Time
+	timer      *time.Timer
+	flushMutex sync.Mutex
+}
+
+// push adds the change set to the pending notification and (re)starts the quiet period timer,
+// making sure that we never hold a notification for longer than maxWait.
+func (d *debouncer) push(changes ChangeSet) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	if d.pending == nil {
+		d.pending = &changes
+		d.first = now
+	} else {
+		merged := merge(*d.pending, changes)
+		d.pending = &merged
+	}
+
+	delay := d.quiet
+	if d.maxWait > 0 {
+		if remaining := d.first.Add(d.maxWait).Sub(now); remaining < delay {
+			delay = remaining
+		}
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(delay, d.flush)
+}
+
+// flush notifies watchers about everything that's pending. Flushes are serialized so that watchers
+// never hear about two sets of changes at the same time (or out of order).
+func (d *debouncer) flush() {
+	d.flushMutex.Lock()
+	defer d.flushMutex.Unlock()
+
+	d.mutex.Lock()
+	pending := d.pending
+	d.pending = nil
+	d.mutex.Unlock()
+
+	// Everything may have changed back to how it was before the burst started.
+	if pending != nil && len(pending.Changes) > 0 {
+		d.notify(*pending)
+	}
+}
+
+// stop cancels any pending notification.
+func (d *debouncer) stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.pending = nil
+}
@@ -0,0 +1,286 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"gopkg.in/yaml.v2"
+)
+
+// Decoder parses a structured document (JSON, YAML, HCL, etc) into nested maps, slices and
+// scalar values, just like json.Unmarshal does when you give it an interface{}.
+type Decoder func(data []byte) (interface{}, error)
+
+// DecodeJSON is the Decoder for JSON documents. Numbers are kept exactly as they're written
+// so that big integers don't lose precision by passing through a float64.
+func DecodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document interface{}
+	err := decoder.Decode(&document)
+	return document, err
+}
+
+// DecodeYAML is the Decoder for YAML documents.
+func DecodeYAML(data []byte) (interface{}, error) {
+	var document interface{}
+	err := yaml.Unmarshal(data, &document)
+	return document, err
+}
+
+// DecodeHCL is the Decoder for HCL documents. Blocks (e.g. "db { port = 5432 }") come back as
+// lists of maps, but you can still use "db.port" to read the values inside of them.
+func DecodeHCL(data []byte) (interface{}, error) {
+	var document map[string]interface{}
+	err := hcl.Unmarshal(data, &document)
+	return document, err
+}
+
+// documentSeparator splits a document key from the path within it (e.g. "config.json#db.port").
+const documentSeparator = "#"
+
+// splitDocumentKey breaks "config.json#db.port" into "config.json" and "db.port". The ok flag is
+// false for regular keys w/o a path.
+func splitDocumentKey(key string) (document string, path string, ok bool) {
+	parts := strings.SplitN(key, documentSeparator, 2)
+	if len(parts) != 2 {
+		return key, "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// parseDocuments decodes each of the designated document keys in the snapshot. A document that
+// doesn't parse is an error so the refresh rejects it like any other bad value.
+func parseDocuments(snap *snapshot, decoders map[string]Decoder) (map[string]interface{}, error) {
+	documents := map[string]interface{}{}
+	for key, decoder := range decoders {
+		key = snap.mapKey(key)
+		entry, ok := snap.values[key]
+		if !ok {
+			continue
+		}
+		if decoder == nil {
+			decoder = DecodeJSON
+		}
+		document, err := decoder(entry.pair.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid document %s: %v", entry.pair.Key, err)
+		}
+		documents[key] = document
+	}
+	return documents, nil
+}
+
+// lookupDocument finds the value at the path (e.g. "db.port") within the document. Use numbers
+// to index into lists (e.g. "servers.0.host").
+func (snap *snapshot) lookupDocument(document string, path string) (string, bool) {
+	node, ok := snap.documents[document]
+	if !ok {
+		return "", false
+	}
+	for _, segment := range strings.Split(path, ".") {
+		if node, ok = child(node, segment); !ok {
+			return "", false
+		}
+	}
+	return scalar(node)
+}
+
+// child descends one level into the document. HCL decodes blocks as a single-element list of
+// maps, so we quietly step into those when you use a name rather than an index.
+func child(node interface{}, segment string) (interface{}, bool) {
+	switch typed := node.(type) {
+	case map[string]interface{}:
+		value, ok := typed[segment]
+		return value, ok
+	case map[interface{}]interface{}:
+		value, ok := typed[segment]
+		return value, ok
+	case []map[string]interface{}:
+		if index, err := strconv.Atoi(segment); err == nil && index >= 0 && index < len(typed) {
+			return typed[index], true
+		}
+		if len(typed) == 1 {
+			return child(typed[0], segment)
+		}
+	case []interface{}:
+		if index, err := strconv.Atoi(segment); err == nil && index >= 0 && index < len(typed) {
+			return typed[index], true
+		}
+		if len(typed) == 1 {
+			return child(typed[0], segment)
+		}
+	}
+	return nil, false
+}
+
+// scalar converts a decoded value into the same kind of string you'd store in a regular key so
+// that the normal getters can parse it. Lists of scalars become comma-separated lists, and
+// anything more complicated comes back as JSON.
+func scalar(node interface{}) (string, bool) {
+	switch typed := node.(type) {
+	case nil:
+		return "", false
+	case string:
+		return typed, true
+	case json.Number:
+		return typed.String(), true
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64), true
+	case bool, int, int64, uint64:
+		return fmt.Sprint(typed), true
+	case []interface{}:
+		values := make([]string, len(typed))
+		for i, item := range typed {
+			value, ok := scalar(item)
+			if !ok || isComposite(item) {
+				return marshal(node)
+			}
+			values[i] = value
+		}
+		return strings.Join(values, ","), true
+	}
+	return marshal(node)
+}
+
+func isComposite(node interface{}) bool {
+	switch node.(type) {
+	case map[string]interface{}, map[interface{}]interface{}, []interface{}, []map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+func marshal(node interface{}) (string, bool) {
+	data, err := json.Marshal(jsonable(node))
+	if err != nil {
+		return fmt.Sprint(node), true
+	}
+	return string(data), true
+}
+
+// jsonable converts the map[interface{}]interface{} values that YAML decodes into (and which
+// encoding/json refuses to marshal) into map[string]interface{} all the way down.
+func jsonable(node interface{}) interface{} {
+	switch typed := node.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for key, value := range typed {
+			converted[fmt.Sprint(key)] = jsonable(value)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for key, value := range typed {
+			converted[key] = jsonable(value)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(typed))
+		for i, value := range typed {
+			converted[i] = jsonable(value)
+		}
+		return converted
+	}
+	return node
+}
+
+// flatten collects every scalar value in the document keyed by its path.
+func flatten(node interface{}, path string, leaves map[string]string) {
+	visit := func(segment string, value interface{}) {
+		if path != "" {
+			segment = path + "." + segment
+		}
+		flatten(value, segment, leaves)
+	}
+	switch typed := node.(type) {
+	case map[string]interface{}:
+		for key, value := range typed {
+			visit(key, value)
+		}
+	case map[interface{}]interface{}:
+		for key, value := range typed {
+			visit(fmt.Sprint(key), value)
+		}
+	case []map[string]interface{}:
+		for i, value := range typed {
+			visit(strconv.Itoa(i), value)
+		}
+	case []interface{}:
+		for i, value := range typed {
+			visit(strconv.Itoa(i), value)
+		}
+	default:
+		if value, ok := scalar(node); ok && path != "" {
+			leaves[path] = value
+		}
+	}
+}
+
+// diffDocuments describes changes to the individual values inside of your documents (e.g.
+// "config.json#db.port") so that you can watch them just like regular keys.
+func diffDocuments(before *snapshot, after *snapshot) []Change {
+	var changes []Change
+	keys := map[string]bool{}
+	for key := range before.documents {
+		keys[key] = true
+	}
+	for key := range after.documents {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		oldEntry, newEntry := before.values[key], after.values[key]
+		if oldEntry.pair != nil && newEntry.pair != nil && bytes.Equal(oldEntry.pair.Value, newEntry.pair.Value) {
+			continue
+		}
+
+		oldLeaves, newLeaves := map[string]string{}, map[string]string{}
+		flatten(before.documents[key], "", oldLeaves)
+		flatten(after.documents[key], "", newLeaves)
+		for path, newValue := range newLeaves {
+			oldValue, ok := oldLeaves[path]
+			switch {
+			case !ok:
+				changes = append(changes, newDocumentChange(key, path, KeyAdded, oldEntry, newEntry, "", newValue))
+			case oldValue != newValue:
+				changes = append(changes, newDocumentChange(key, path, KeyModified, oldEntry, newEntry, oldValue, newValue))
+			}
+		}
+		for path, oldValue := range oldLeaves {
+			if _, ok := newLeaves[path]; !ok {
+				changes = append(changes, newDocumentChange(key, path, KeyDeleted, oldEntry, newEntry, oldValue, ""))
+			}
+		}
+	}
+	return changes
+}
+
+func newDocumentChange(key string, path string, changeType ChangeType, oldEntry entry, newEntry entry, oldValue string, newValue string) Change {
+	change := Change{
+		Key:      key + documentSeparator + path,
+		Type:     changeType,
+		OldValue: oldValue,
+		NewValue: newValue,
+	}
+	if oldEntry.pair != nil {
+		change.QualifiedKey = oldEntry.pair.Key + documentSeparator + path
+		change.OldIndex = oldEntry.pair.ModifyIndex
+	}
+	if newEntry.pair != nil {
+		change.QualifiedKey = newEntry.pair.Key + documentSeparator + path
+		change.NewIndex = newEntry.pair.ModifyIndex
+	}
+	if changeType == KeyAdded {
+		change.OldIndex = 0
+	}
+	if changeType == KeyDeleted {
+		change.NewIndex = 0
+	}
+	return change
+}
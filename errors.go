@@ -0,0 +1,155 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorKind classifies the reasons that we might fail to refresh config values from Consul.
+type ErrorKind int
+
+const (
+	// KindUnknown is a failure that doesn't fall into any of the other categories.
+	KindUnknown ErrorKind = iota
+	// KindConnection means that we couldn't reach the agent (or the agent couldn't reach its servers).
+	KindConnection
+	// KindPermission means that Consul rejected our ACL token.
+	KindPermission
+	// KindNotFound means that there are no keys in your namespace.
+	KindNotFound
+	// KindDecode means that we got a response from Consul, but it didn't make any sense.
+	KindDecode
+	// KindWatcher means that we got new values just fine, but one of your watchers panicked.
+	KindWatcher
+	// KindInvalid means that your validator rejected the new values, so we're still serving the
+	// last values that it accepted.
+	KindInvalid
+)
+
+func (kind ErrorKind) String() string {
+	switch kind {
+	case KindConnection:
+		return "connection"
+	case KindPermission:
+		return "permission"
+	case KindNotFound:
+		return "not found"
+	case KindDecode:
+		return "decode"
+	case KindWatcher:
+		return "watcher"
+	case KindInvalid:
+		return "invalid"
+	default:
+		return "unknown"
+	}
+}
+
+// RefreshError describes a failed attempt to load config values from Consul.
+type RefreshError struct {
+	// Kind classifies the failure so you can decide how loudly to complain about it.
+	Kind ErrorKind
+	// Namespace is the key prefix that we were trying to load.
+	Namespace string
+	// Err is the underlying error from the Consul client.
+	Err error
+}
+
+func (err *RefreshError) Error() string {
+	return "consul source: " + err.Kind.String() + " error refreshing '" + err.Namespace + "': " + err.Err.Error()
+}
+
+// Unwrap returns the underlying error so that RefreshError plays nice with errors.Is/As.
+func (err *RefreshError) Unwrap() error {
+	return err.Err
+}
+
+func newRefreshError(err error, namespace string) *RefreshError {
+	return &RefreshError{Kind: classify(err), Namespace: namespace, Err: err}
+}
+
+// classify figures out which ErrorKind best describes the error. The Consul client doesn't give
+// us typed errors for bad status codes, so we have to sniff the message for those.
+func classify(err error) ErrorKind {
+	if err == nil {
+		return KindUnknown
+	}
+
+	switch cause := errors.Cause(err).(type) {
+	case *RefreshError:
+		return cause.Kind
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return KindDecode
+	case *url.Error, net.Error:
+		return KindConnection
+	}
+
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "Unexpected response code: 403"), strings.Contains(message, "Permission denied"):
+		return KindPermission
+	case strings.Contains(message, "Unexpected response code: 404"):
+		return KindNotFound
+	case strings.Contains(message, "Unexpected response code: 5"):
+		return KindConnection
+	case strings.Contains(message, "Failed to parse"):
+		return KindDecode
+	}
+	return KindUnknown
+}
+
+var (
+	// ErrMissing means that the key isn't in Consul (and there's no default for it).
+	ErrMissing = errors.New("key not found")
+	// ErrMalformed means that the key is in Consul, but its value doesn't parse as the type
+	// you asked for (e.g. "12a4" as an int).
+	ErrMalformed = errors.New("malformed value")
+	// ErrOverflow means that the value parses as a number, but it doesn't fit in the type you
+	// asked for (e.g. "70000" as an int16).
+	ErrOverflow = errors.New("value out of range")
+)
+
+// LookupError explains why we couldn't give you a value for a key. Its Err is always one of
+// ErrMissing, ErrMalformed or ErrOverflow so you can tell a missing key from a broken value.
+type LookupError struct {
+	// Key is the key relative to your namespace (i.e. what you passed to the lookup).
+	Key string
+	// QualifiedKey is the full key in Consul, including the namespace.
+	QualifiedKey string
+	// Value is the raw value that we tried to parse. It's empty when the key is missing.
+	Value string
+	// Type is the type that you asked for.
+	Type FieldType
+	// ModifyIndex is the Consul ModifyIndex of the value. It's 0 when the value didn't come
+	// from Consul (e.g. it's missing or it's a default).
+	ModifyIndex uint64
+	// DefaultApplied means that the key wasn't in Consul, so Value came from the Defaults option.
+	DefaultApplied bool
+	// Err is ErrMissing, ErrMalformed or ErrOverflow.
+	Err error
+}
+
+func (err *LookupError) Error() string {
+	message := fmt.Sprintf("consul source: %s: %v", err.QualifiedKey, err.Err)
+	if err.Err == ErrMissing {
+		return message
+	}
+	message += fmt.Sprintf(": '%s' as %s", err.Value, err.Type)
+	switch {
+	case err.DefaultApplied:
+		message += " (from defaults)"
+	case err.ModifyIndex > 0:
+		message += fmt.Sprintf(" (modify index %d)", err.ModifyIndex)
+	}
+	return message
+}
+
+// Unwrap returns the underlying sentinel so that LookupError plays nice with errors.Is.
+func (err *LookupError) Unwrap() error {
+	return err.Err
+}
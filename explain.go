@@ -0,0 +1,95 @@
+package consul
+
+// Origin identifies the layer that ultimately supplied a key's value.
+type Origin int
+
+const (
+	// OriginNone means that nobody had a value for the key.
+	OriginNone Origin = iota
+	// OriginConsul means that the value came from Consul.
+	OriginConsul
+	// OriginSchema means that the key wasn't in Consul, so we used the Default from your schema.
+	OriginSchema
+	// OriginDefaults means that the key wasn't in Consul or your schema, so we used the Defaults option.
+	OriginDefaults
+)
+
+func (origin Origin) String() string {
+	switch origin {
+	case OriginConsul:
+		return "consul"
+	case OriginSchema:
+		return "schema"
+	case OriginDefaults:
+		return "defaults"
+	default:
+		return "none"
+	}
+}
+
+// Explanation describes where a key's value came from so that you can answer questions like
+// "why is RETRY_COUNT 3 in prod?" w/o guessing.
+type Explanation struct {
+	// Key is the key relative to your namespace (i.e. what you passed to Explain).
+	Key string
+	// QualifiedKey is the full key in Consul that supplied the value. When the key isn't in
+	// Consul, it's the key that we looked for in your namespace.
+	QualifiedKey string
+	// Searched lists every key that we looked for in Consul, in order of precedence.
+	Searched []string
+	// Found is true when the key exists in Consul.
+	Found bool
+	// Layer is the prefix (your namespace or one of your Layers) that supplied the value.
+	Layer string
+	// Value is the raw value from whichever layer supplied it.
+	Value []byte
+	// ModifyIndex is the Consul index of the last write to the key (0 if it's not in Consul).
+	ModifyIndex uint64
+	// LastIndex is the Consul index of the snapshot that we looked in.
+	LastIndex uint64
+	// Flags are the opaque flags that whoever wrote the key attached to it.
+	Flags uint64
+	// Session is the ID of the session holding a lock on the key, if any.
+	Session string
+	// Origin is the layer that ultimately supplied the value.
+	Origin Origin
+}
+
+// Explain describes where the key's value comes from in this snapshot.
+func (s snapshotSource) Explain(key string) Explanation {
+	explanation := Explanation{
+		Key:          key,
+		QualifiedKey: s.options.Namespace.Qualify(key),
+		LastIndex:    s.snapshot.index,
+	}
+	for _, layer := range s.snapshot.layers {
+		explanation.Searched = append(explanation.Searched, layerPrefix(layer, namespaceDelimiter(s.options))+key)
+	}
+	if found, foundKey, value, ok := s.snapshot.find(key); ok {
+		pair := found.pair
+		explanation.QualifiedKey = foundKey
+		explanation.Layer = found.layer
+		explanation.Found = true
+		explanation.Value = append([]byte(nil), value...)
+		explanation.ModifyIndex = pair.ModifyIndex
+		explanation.Flags = pair.Flags
+		explanation.Session = pair.Session
+		explanation.Origin = OriginConsul
+		return explanation
+	}
+	if value, ok := s.schema.defaults[mapLookupKey(s.snapshot.mapKey, key)]; ok {
+		explanation.Value = []byte(value)
+		explanation.Origin = OriginSchema
+		return explanation
+	}
+	if value, ok := s.options.Defaults.String(key); ok {
+		explanation.Value = []byte(value)
+		explanation.Origin = OriginDefaults
+	}
+	return explanation
+}
+
+// Explain describes where the key's current value comes from.
+func (c *consulSource) Explain(key string) Explanation {
+	return c.pinned().Explain(key)
+}
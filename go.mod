@@ -4,7 +4,9 @@ go 1.13
 
 require (
 	github.com/hashicorp/consul/api v1.2.0
+	github.com/hashicorp/hcl v1.0.0
 	github.com/pkg/errors v0.8.1
 	github.com/robsignorelli/configify v1.1.3
 	github.com/stretchr/testify v1.3.0
+	gopkg.in/yaml.v2 v2.4.0
 )
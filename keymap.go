@@ -0,0 +1,49 @@
+package consul
+
+import (
+	"strings"
+)
+
+// KeyMapping translates keys into a canonical form so that the keys in Consul don't have to look
+// exactly like the keys that your code asks for. We run every key (relative to your namespace)
+// from Consul through it, along w/ every key that you look up, and they match when their
+// canonical forms are the same. Keys() and change notifications report the canonical form.
+type KeyMapping func(key string) string
+
+// SlashToUnderscore lets you store hierarchical keys in Consul (e.g. "http/host") while your code
+// asks for flat ones (e.g. "http_host").
+func SlashToUnderscore(key string) string {
+	return strings.Replace(key, "/", "_", -1)
+}
+
+// CaseInsensitive lets "HTTP_HOST", "http_host" and "Http_Host" all refer to the same key. The
+// canonical form is upper case, so that's what you'll see in Keys().
+func CaseInsensitive(key string) string {
+	return strings.ToUpper(key)
+}
+
+// ChainKeyMappings applies each of the mappings in order. For instance, chaining SlashToUnderscore
+// and CaseInsensitive lets your code ask for "HTTP_HOST" when the key in Consul is "http/host".
+func ChainKeyMappings(mappings ...KeyMapping) KeyMapping {
+	return func(key string) string {
+		for _, mapping := range mappings {
+			key = mapping(key)
+		}
+		return key
+	}
+}
+
+// identityMapping leaves keys exactly how they are, which is what you get by default.
+func identityMapping(key string) string {
+	return key
+}
+
+// mapLookupKey maps the key that you asked for into its canonical form. For paths within a
+// document (e.g. "config.json#db.port"), only the document's key is mapped; the path is matched
+// exactly against the document's contents.
+func mapLookupKey(mapping KeyMapping, key string) string {
+	if document, path, ok := splitDocumentKey(key); ok {
+		return mapping(document) + documentSeparator + path
+	}
+	return mapping(key)
+}
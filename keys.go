@@ -0,0 +1,67 @@
+package consul
+
+import (
+	"sort"
+	"strings"
+)
+
+// Redacted is what you see in place of secret values when you dump your config.
+const Redacted = "[REDACTED]"
+
+// Keys returns every key (relative to your namespace) that has a value in this snapshot, either
+// from Consul or from your schema's defaults. They're sorted so that dumps are easy to diff.
+func (s snapshotSource) Keys() []string {
+	unique := map[string]bool{}
+	for key := range s.snapshot.values {
+		unique[key] = true
+	}
+	for key := range s.schema.defaults {
+		unique[key] = true
+	}
+
+	keys := make([]string, 0, len(unique))
+	for key := range unique {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// All returns the effective value of every key in Keys(). Secret values are redacted so that
+// it's safe to log the result; use the normal getters when you need the real thing.
+func (s snapshotSource) All() map[string]string {
+	return s.Prefix("")
+}
+
+// Prefix is like All(), but it only includes the keys that start w/ the given prefix (e.g.
+// "DB_"). The keys in the result still include the prefix.
+func (s snapshotSource) Prefix(prefix string) map[string]string {
+	values := map[string]string{}
+	prefix = s.snapshot.mapKey(prefix)
+	for _, key := range s.Keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if s.schema.secret(key) {
+			values[key] = Redacted
+			continue
+		}
+		values[key], _ = s.lookup(key)
+	}
+	return values
+}
+
+// Keys returns every key (relative to your namespace) that currently has a value.
+func (c *consulSource) Keys() []string {
+	return c.pinned().Keys()
+}
+
+// All returns the current value of every key w/ secrets redacted.
+func (c *consulSource) All() map[string]string {
+	return c.pinned().All()
+}
+
+// Prefix returns the current value of every key that starts w/ the prefix w/ secrets redacted.
+func (c *consulSource) Prefix(prefix string) map[string]string {
+	return c.pinned().Prefix(prefix)
+}
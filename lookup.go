@@ -0,0 +1,213 @@
+package consul
+
+import (
+	"time"
+)
+
+// LookupSource has an error-returning version of each standard configify.Source getter. Rather
+// than a bare false, you get a *LookupError that tells you exactly which key in Consul we looked
+// at, what we found there and why we couldn't use it. These always parse strictly, so values that
+// would overflow the type are errors even when the Strict config option is off.
+type LookupSource interface {
+	StringE(key string) (string, error)
+	StringSliceE(key string) ([]string, error)
+	IntE(key string) (int, error)
+	Int8E(key string) (int8, error)
+	Int16E(key string) (int16, error)
+	Int32E(key string) (int32, error)
+	Int64E(key string) (int64, error)
+	UintE(key string) (uint, error)
+	Uint8E(key string) (uint8, error)
+	Uint16E(key string) (uint16, error)
+	Uint32E(key string) (uint32, error)
+	Uint64E(key string) (uint64, error)
+	Float32E(key string) (float32, error)
+	Float64E(key string) (float64, error)
+	BoolE(key string) (bool, error)
+	DurationE(key string) (time.Duration, error)
+	TimeE(key string) (time.Time, error)
+}
+
+// strictly returns a copy of the source that refuses to wrap values that don't fit in their type.
+func (s snapshotSource) strictly() snapshotSource {
+	s.strict = true
+	return s
+}
+
+// failure explains why a (strict) getter came back w/ ok=false. Lookups only fall back to the
+// Defaults option when the key is missing, so when the default is there but we still failed, it
+// must be the default that doesn't parse. We classify it just like we would a value from Consul.
+func (s snapshotSource) failure(key string, fieldType FieldType, ok bool) error {
+	if ok {
+		return nil
+	}
+	err := s.diagnose(key, fieldType)
+	if err == nil {
+		// The getters and diagnose() agree on what's valid, so this shouldn't happen.
+		return &LookupError{Key: key, QualifiedKey: s.options.Namespace.Qualify(key), Type: fieldType, Err: ErrMalformed}
+	}
+	if err.Err == ErrMissing {
+		if value, ok := s.options.Defaults.String(key); ok {
+			err.Value = value
+			err.DefaultApplied = true
+			if _, _, err.Err = parseField(fieldType, value, s.massage); err.Err == nil {
+				// It parses fine as text, but the Defaults source won't give it to us as this type.
+				err.Err = ErrMalformed
+			}
+		}
+	}
+	return err
+}
+
+func (s snapshotSource) StringE(key string) (string, error) {
+	value, ok := s.strictly().String(key)
+	return value, s.failure(key, TypeString, ok)
+}
+
+func (s snapshotSource) StringSliceE(key string) ([]string, error) {
+	value, ok := s.strictly().StringSlice(key)
+	return value, s.failure(key, TypeStringSlice, ok)
+}
+
+func (s snapshotSource) IntE(key string) (int, error) {
+	value, ok := s.strictly().Int(key)
+	return value, s.failure(key, TypeInt, ok)
+}
+
+func (s snapshotSource) Int8E(key string) (int8, error) {
+	value, ok := s.strictly().Int8(key)
+	return value, s.failure(key, TypeInt8, ok)
+}
+
+func (s snapshotSource) Int16E(key string) (int16, error) {
+	value, ok := s.strictly().Int16(key)
+	return value, s.failure(key, TypeInt16, ok)
+}
+
+func (s snapshotSource) Int32E(key string) (int32, error) {
+	value, ok := s.strictly().Int32(key)
+	return value, s.failure(key, TypeInt32, ok)
+}
+
+func (s snapshotSource) Int64E(key string) (int64, error) {
+	value, ok := s.strictly().Int64(key)
+	return value, s.failure(key, TypeInt64, ok)
+}
+
+func (s snapshotSource) UintE(key string) (uint, error) {
+	value, ok := s.strictly().Uint(key)
+	return value, s.failure(key, TypeUint, ok)
+}
+
+func (s snapshotSource) Uint8E(key string) (uint8, error) {
+	value, ok := s.strictly().Uint8(key)
+	return value, s.failure(key, TypeUint8, ok)
+}
+
+func (s snapshotSource) Uint16E(key string) (uint16, error) {
+	value, ok := s.strictly().Uint16(key)
+	return value, s.failure(key, TypeUint16, ok)
+}
+
+func (s snapshotSource) Uint32E(key string) (uint32, error) {
+	value, ok := s.strictly().Uint32(key)
+	return value, s.failure(key, TypeUint32, ok)
+}
+
+func (s snapshotSource) Uint64E(key string) (uint64, error) {
+	value, ok := s.strictly().Uint64(key)
+	return value, s.failure(key, TypeUint64, ok)
+}
+
+func (s snapshotSource) Float32E(key string) (float32, error) {
+	value, ok := s.strictly().Float32(key)
+	return value, s.failure(key, TypeFloat32, ok)
+}
+
+func (s snapshotSource) Float64E(key string) (float64, error) {
+	value, ok := s.strictly().Float64(key)
+	return value, s.failure(key, TypeFloat64, ok)
+}
+
+func (s snapshotSource) BoolE(key string) (bool, error) {
+	value, ok := s.strictly().Bool(key)
+	return value, s.failure(key, TypeBool, ok)
+}
+
+func (s snapshotSource) DurationE(key string) (time.Duration, error) {
+	value, ok := s.strictly().Duration(key)
+	return value, s.failure(key, TypeDuration, ok)
+}
+
+func (s snapshotSource) TimeE(key string) (time.Time, error) {
+	value, ok := s.strictly().Time(key)
+	return value, s.failure(key, TypeTime, ok)
+}
+
+func (c *consulSource) StringE(key string) (string, error) {
+	return c.pinned().StringE(key)
+}
+
+func (c *consulSource) StringSliceE(key string) ([]string, error) {
+	return c.pinned().StringSliceE(key)
+}
+
+func (c *consulSource) IntE(key string) (int, error) {
+	return c.pinned().IntE(key)
+}
+
+func (c *consulSource) Int8E(key string) (int8, error) {
+	return c.pinned().Int8E(key)
+}
+
+func (c *consulSource) Int16E(key string) (int16, error) {
+	return c.pinned().Int16E(key)
+}
+
+func (c *consulSource) Int32E(key string) (int32, error) {
+	return c.pinned().Int32E(key)
+}
+
+func (c *consulSource) Int64E(key string) (int64, error) {
+	return c.pinned().Int64E(key)
+}
+
+func (c *consulSource) UintE(key string) (uint, error) {
+	return c.pinned().UintE(key)
+}
+
+func (c *consulSource) Uint8E(key string) (uint8, error) {
+	return c.pinned().Uint8E(key)
+}
+
+func (c *consulSource) Uint16E(key string) (uint16, error) {
+	return c.pinned().Uint16E(key)
+}
+
+func (c *consulSource) Uint32E(key string) (uint32, error) {
+	return c.pinned().Uint32E(key)
+}
+
+func (c *consulSource) Uint64E(key string) (uint64, error) {
+	return c.pinned().Uint64E(key)
+}
+
+func (c *consulSource) Float32E(key string) (float32, error) {
+	return c.pinned().Float32E(key)
+}
+
+func (c *consulSource) Float64E(key string) (float64, error) {
+	return c.pinned().Float64E(key)
+}
+
+func (c *consulSource) BoolE(key string) (bool, error) {
+	return c.pinned().BoolE(key)
+}
+
+func (c *consulSource) DurationE(key string) (time.Duration, error) {
+	return c.pinned().DurationE(key)
+}
+
+func (c *consulSource) TimeE(key string) (time.Time, error) {
+	return c.pinned().TimeE(key)
+}
@@ -0,0 +1,81 @@
+package consul
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// OverrideConfig lets you override values for a single host or group of service instances (e.g.
+// for a canary rollout) by writing them beneath your namespace:
+//
+//	<namespace>/overrides/node/<node name>/KEY
+//	<namespace>/overrides/tag/<service tag>/KEY
+//
+// Node overrides win over tag overrides, which win over the regular <namespace>/KEY.
+type OverrideConfig struct {
+	// Node turns on per-node overrides.
+	Node bool
+	// NodeName is the node whose overrides we use. It defaults to the name of the Consul agent
+	// that we're talking to (i.e. the one running on this host).
+	NodeName string
+	// Tags are the service tags whose overrides we use (e.g. "canary"). When more than one tag
+	// has an override for a key, the earliest tag in the list wins.
+	Tags []string
+}
+
+func (config OverrideConfig) enabled() bool {
+	return config.Node || len(config.Tags) > 0
+}
+
+// discoverNode asks the agent for its node name if you want node overrides and haven't told us
+// which node you're on. Once we know it, we start resolving keys w/ the node's overrides. Until
+// then (e.g. our token can't read the agent), we resolve keys w/o them and try again next time.
+func (c *consulSource) discoverNode() error {
+	if !c.overrides.Node || c.overrides.NodeName != "" {
+		return nil
+	}
+
+	self := map[string]map[string]interface{}{}
+	query := &api.QueryOptions{Token: c.token}
+	if _, err := c.client.Raw().Query("/v1/agent/self", &self, query.WithContext(c.options.Context)); err != nil {
+		return errors.Wrap(err, "unable to discover node name")
+	}
+	nodeName, _ := self["Config"]["NodeName"].(string)
+	if nodeName == "" {
+		return errors.New("unable to discover node name: agent did not report one")
+	}
+	c.overrides.NodeName = nodeName
+	c.layers = c.buildLayers()
+	// We have to re-resolve every key even if nothing changed in Consul.
+	c.lastIndex = 0
+	return nil
+}
+
+// buildLayers lists every prefix that can supply a value in order of precedence: node overrides,
+// tag overrides, your namespace and then your fallback Layers.
+func (c *consulSource) buildLayers() []string {
+	var layers []string
+	if c.overrides.Node && c.overrides.NodeName != "" {
+		layers = append(layers, c.overridePrefix("node", c.overrides.NodeName))
+	}
+	for _, tag := range c.overrides.Tags {
+		layers = append(layers, c.overridePrefix("tag", tag))
+	}
+	layers = append(layers, c.options.Namespace.Name)
+	return append(layers, c.fallbacks...)
+}
+
+// overridePrefix qualifies the override path (e.g. "overrides/node/web-1") w/ your namespace.
+func (c *consulSource) overridePrefix(segments ...string) string {
+	path := c.options.Namespace.Join(append([]string{"overrides"}, segments...)...)
+	return c.options.Namespace.Qualify(path)
+}
+
+// hiddenPrefixes are the qualified prefixes whose keys shouldn't show up as regular keys in
+// your namespace (e.g. "overrides/node/web-2/HTTP_PORT" from some other node).
+func (c *consulSource) hiddenPrefixes() []string {
+	if !c.overrides.enabled() {
+		return nil
+	}
+	return []string{c.overridePrefix() + namespaceDelimiter(c.options)}
+}
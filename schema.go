@@ -0,0 +1,320 @@
+package consul
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/robsignorelli/configify"
+)
+
+// FieldType is the type of value that you expect to find in a schema field.
+type FieldType int
+
+const (
+	// TypeString accepts any value.
+	TypeString FieldType = iota
+	// TypeStringSlice accepts a comma-separated list of values.
+	TypeStringSlice
+	// TypeInt requires a value that parses as an int.
+	TypeInt
+	// TypeInt8 requires a value that parses as an int8 w/o overflowing.
+	TypeInt8
+	// TypeInt16 requires a value that parses as an int16 w/o overflowing.
+	TypeInt16
+	// TypeInt32 requires a value that parses as an int32 w/o overflowing.
+	TypeInt32
+	// TypeInt64 requires a value that parses as an int64.
+	TypeInt64
+	// TypeUint requires a value that parses as a uint.
+	TypeUint
+	// TypeUint8 requires a value that parses as a uint8 w/o overflowing.
+	TypeUint8
+	// TypeUint16 requires a value that parses as a uint16 w/o overflowing.
+	TypeUint16
+	// TypeUint32 requires a value that parses as a uint32 w/o overflowing.
+	TypeUint32
+	// TypeUint64 requires a value that parses as a uint64.
+	TypeUint64
+	// TypeFloat32 requires a value that parses as a float32 w/o overflowing.
+	TypeFloat32
+	// TypeFloat64 requires a value that parses as a float64.
+	TypeFloat64
+	// TypeBool requires a value that parses as a bool.
+	TypeBool
+	// TypeDuration requires a value that parses as a time.Duration (e.g. "5m3s").
+	TypeDuration
+	// TypeTime requires a value that parses as a time.Time (e.g. "2019-12-25").
+	TypeTime
+)
+
+var fieldTypeNames = map[FieldType]string{
+	TypeString:      "string",
+	TypeStringSlice: "string slice",
+	TypeInt:         "int",
+	TypeInt8:        "int8",
+	TypeInt16:       "int16",
+	TypeInt32:       "int32",
+	TypeInt64:       "int64",
+	TypeUint:        "uint",
+	TypeUint8:       "uint8",
+	TypeUint16:      "uint16",
+	TypeUint32:      "uint32",
+	TypeUint64:      "uint64",
+	TypeFloat32:     "float32",
+	TypeFloat64:     "float64",
+	TypeBool:        "bool",
+	TypeDuration:    "duration",
+	TypeTime:        "time",
+}
+
+func (fieldType FieldType) String() string {
+	if name, ok := fieldTypeNames[fieldType]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Range limits the values allowed in a numeric field (inclusive).
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// Field declares a single key that you expect to find in your namespace.
+type Field struct {
+	// Key is the key relative to your namespace (i.e. what you'd pass to source.String()).
+	Key string
+	// Type is the type that the value must parse as.
+	Type FieldType
+	// Required means that the key must have a value in Consul (or a Default).
+	Required bool
+	// Default is the raw value we use when the key isn't in Consul. It's parsed exactly like
+	// a value from Consul would be, so a duration default looks like "5s".
+	Default string
+	// Range limits the allowed values of numeric fields.
+	Range *Range
+	// Pattern is a regular expression that the raw value must match.
+	Pattern string
+	// Description explains what the key is for. We include it in validation errors so that
+	// whoever is on call knows what they're dealing with.
+	Description string
+	// Secret means that the value is redacted when you dump your config w/ All() or Prefix().
+	Secret bool
+}
+
+// Schema declares the keys that you expect to find in your namespace. The source enforces it when
+// loading values and on every refresh, and it uses the fields' defaults when keys are missing.
+type Schema []Field
+
+// SchemaError describes every problem we found when checking values against your schema.
+type SchemaError struct {
+	Problems []FieldError
+}
+
+func (err *SchemaError) Error() string {
+	messages := make([]string, len(err.Problems))
+	for i, problem := range err.Problems {
+		messages[i] = problem.Error()
+	}
+	return "schema violations: " + strings.Join(messages, "; ")
+}
+
+// FieldError describes a single key that didn't satisfy your schema.
+type FieldError struct {
+	// Field is the schema field that was violated.
+	Field Field
+	// Value is the raw value that we found (or the default).
+	Value string
+	// Problem describes what was wrong w/ the value.
+	Problem string
+}
+
+func (err FieldError) Error() string {
+	message := err.Field.Key + " " + err.Problem
+	if err.Field.Description != "" {
+		message += " (" + err.Field.Description + ")"
+	}
+	return message
+}
+
+// compiledSchema is the schema w/ its patterns compiled and its defaults indexed by key.
+type compiledSchema struct {
+	fields   Schema
+	patterns map[string]*regexp.Regexp
+	defaults map[string]string
+	secrets  []string
+}
+
+// compile makes sure that the schema itself makes sense (patterns compile, defaults are
+// valid) so that you find out about mistakes when you create the source.
+func (schema Schema) compile(massage configify.Massage, mapKey KeyMapping) (*compiledSchema, error) {
+	compiled := &compiledSchema{
+		fields:   schema,
+		patterns: map[string]*regexp.Regexp{},
+		defaults: map[string]string{},
+	}
+	for _, field := range schema {
+		if field.Pattern != "" {
+			pattern, err := regexp.Compile(field.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern for %s: %v", field.Key, err)
+			}
+			compiled.patterns[field.Key] = pattern
+		}
+		if field.Default != "" {
+			if problem := compiled.check(field, field.Default, massage); problem != "" {
+				return nil, fmt.Errorf("invalid default for %s: %s", field.Key, problem)
+			}
+			compiled.defaults[mapLookupKey(mapKey, field.Key)] = field.Default
+		}
+		if field.Secret {
+			compiled.secrets = append(compiled.secrets, mapLookupKey(mapKey, field.Key))
+		}
+	}
+	return compiled, nil
+}
+
+// withSecrets adds more keys (or path.Match patterns like "*_PASSWORD") to redact. We match them
+// against the canonical form of each key, so the patterns go through the key mapping, too.
+func (schema *compiledSchema) withSecrets(patterns []string, mapKey KeyMapping) (*compiledSchema, error) {
+	for _, pattern := range patterns {
+		mapped := mapLookupKey(mapKey, pattern)
+		if _, err := path.Match(mapped, ""); err != nil {
+			return nil, fmt.Errorf("invalid secret pattern '%s': %v", pattern, err)
+		}
+		schema.secrets = append(schema.secrets, mapped)
+	}
+	return schema, nil
+}
+
+// secret returns true when the key's value shouldn't show up in config dumps.
+func (schema *compiledSchema) secret(key string) bool {
+	for _, pattern := range schema.secrets {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks every field in the schema against the candidate values, returning all of the
+// problems at once rather than making you fix them one at a time.
+func (schema *compiledSchema) validate(candidate snapshotSource) error {
+	var problems []FieldError
+	for _, field := range schema.fields {
+		value, ok := candidate.lookup(field.Key)
+		if !ok {
+			if field.Required {
+				problems = append(problems, FieldError{Field: field, Problem: "is required but missing"})
+			}
+			continue
+		}
+		if problem := schema.check(field, value, candidate.massage); problem != "" {
+			problems = append(problems, FieldError{Field: field, Value: value, Problem: problem})
+		}
+	}
+	if len(problems) > 0 {
+		return &SchemaError{Problems: problems}
+	}
+	return nil
+}
+
+// check returns a description of what's wrong w/ the value, or "" if it's fine.
+func (schema *compiledSchema) check(field Field, value string, massage configify.Massage) string {
+	if pattern, ok := schema.patterns[field.Key]; ok && !pattern.MatchString(value) {
+		return fmt.Sprintf("value '%s' does not match pattern '%s'", value, field.Pattern)
+	}
+
+	number, isNumber, err := parseField(field.Type, value, massage)
+	switch {
+	case err == ErrOverflow:
+		return fmt.Sprintf("value '%s' overflows %s", value, field.Type)
+	case err != nil:
+		return fmt.Sprintf("value '%s' is not a valid %s", value, field.Type)
+	}
+	if isNumber && field.Range != nil && (number < field.Range.Min || number > field.Range.Max) {
+		return fmt.Sprintf("value '%s' is not between %v and %v", value, field.Range.Min, field.Range.Max)
+	}
+	return ""
+}
+
+// parseField makes sure that the value parses as the given type w/o overflowing, returning
+// ErrMalformed or ErrOverflow when it doesn't. For numeric types, you also get back the parsed
+// number so that you can range check it.
+func parseField(fieldType FieldType, value string, massage configify.Massage) (float64, bool, error) {
+	switch fieldType {
+	case TypeString:
+		return 0, false, nil
+	case TypeStringSlice:
+		_, ok := massage.StringToSlice(value)
+		return 0, false, malformedUnless(ok)
+	case TypeBool:
+		_, ok := massage.StringToBool(value)
+		return 0, false, malformedUnless(ok)
+	case TypeDuration:
+		_, ok := massage.StringToDuration(value)
+		return 0, false, malformedUnless(ok)
+	case TypeTime:
+		_, ok := massage.StringToTime(value)
+		return 0, false, malformedUnless(ok)
+	case TypeInt, TypeInt8, TypeInt16, TypeInt32, TypeInt64:
+		number, ok := massage.StringToInt64(value)
+		if ok && !intFits(fieldType, number) {
+			return float64(number), true, ErrOverflow
+		}
+		return float64(number), true, malformedUnless(ok)
+	case TypeUint, TypeUint8, TypeUint16, TypeUint32, TypeUint64:
+		number, ok := massage.StringToUint64(value)
+		if ok && !uintFits(fieldType, number) {
+			return float64(number), true, ErrOverflow
+		}
+		return float64(number), true, malformedUnless(ok)
+	case TypeFloat32, TypeFloat64:
+		number, ok := massage.StringToFloat64(value)
+		if ok && fieldType == TypeFloat32 && math.Abs(number) > math.MaxFloat32 {
+			return number, true, ErrOverflow
+		}
+		return number, true, malformedUnless(ok)
+	}
+	return 0, false, ErrMalformed
+}
+
+func malformedUnless(ok bool) error {
+	if ok {
+		return nil
+	}
+	return ErrMalformed
+}
+
+// intFits returns true when the number can be stored in the given signed type w/o overflowing.
+func intFits(fieldType FieldType, number int64) bool {
+	switch fieldType {
+	case TypeInt8:
+		return number >= math.MinInt8 && number <= math.MaxInt8
+	case TypeInt16:
+		return number >= math.MinInt16 && number <= math.MaxInt16
+	case TypeInt32:
+		return number >= math.MinInt32 && number <= math.MaxInt32
+	case TypeInt:
+		return int64(int(number)) == number
+	}
+	return true
+}
+
+// uintFits returns true when the number can be stored in the given unsigned type w/o overflowing.
+func uintFits(fieldType FieldType, number uint64) bool {
+	switch fieldType {
+	case TypeUint8:
+		return number <= math.MaxUint8
+	case TypeUint16:
+		return number <= math.MaxUint16
+	case TypeUint32:
+		return number <= math.MaxUint32
+	case TypeUint:
+		return uint64(uint(number)) == number
+	}
+	return true
+}
@@ -0,0 +1,336 @@
+package consul
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/robsignorelli/configify"
+)
+
+// snapshot is an immutable copy of the namespace's values as of a single Consul index. Once it
+// has been published, nobody modifies it; refresh() builds a new one and swaps it in instead.
+type snapshot struct {
+	index uint64
+	// values maps each key (relative to the namespace) to the pair that supplies its value.
+	values map[string]entry
+	// layers are the prefixes that we looked in, in order of precedence.
+	layers []string
+	// flags are the feature flags decoded from the values beneath the flags prefix.
+	flags map[string]Flag
+	// documents are the decoded values of your structured document keys.
+	documents map[string]interface{}
+	// mapKey translates keys into the canonical form that values is keyed by.
+	mapKey KeyMapping
+}
+
+// entry is the pair that supplies a key's value along w/ the layer (prefix) that it came from.
+type entry struct {
+	pair  *api.KVPair
+	layer string
+}
+
+// newSnapshot figures out which pair supplies each key's value. The layers are in order of
+// precedence, so when more than one of them has a key, the earliest one wins. Pairs outside of
+// every layer (e.g. "FOOD/BAR" when listing "FOO") are ignored since you can never look them up,
+// as are pairs beneath a hidden prefix unless the layer itself is beneath it. Pairs that belong to
+// a layer nested inside of another one (e.g. "svc/orders/prod/HOST" w/ a "svc/orders" layer) only
+// count for the nested layer. Keys are stored in their canonical form according to the key mapping.
+func newSnapshot(index uint64, pairs api.KVPairs, layers []string, delimiter string, hidden []string, mapKey KeyMapping) *snapshot {
+	snap := &snapshot{index: index, values: map[string]entry{}, layers: layers, mapKey: mapKey}
+	for i := len(layers) - 1; i >= 0; i-- {
+		prefix := layerPrefix(layers[i], delimiter)
+		skipped := append(nestedLayers(layers, i, delimiter), hidden...)
+		for _, pair := range pairs {
+			if strings.HasPrefix(pair.Key, prefix) && !isHidden(pair.Key, prefix, skipped) {
+				snap.values[mapKey(strings.TrimPrefix(pair.Key, prefix))] = entry{pair: pair, layer: layers[i]}
+			}
+		}
+	}
+	return snap
+}
+
+// find returns the pair that supplies the key's value along w/ its full key and raw value. For a
+// path within a document (e.g. "config.json#db.port"), that's the document's pair, the path
+// tacked onto the document's key and the value at that path.
+func (snap *snapshot) find(key string) (entry, string, []byte, bool) {
+	key = mapLookupKey(snap.mapKey, key)
+	if document, path, ok := splitDocumentKey(key); ok {
+		if value, ok := snap.lookupDocument(document, path); ok {
+			found := snap.values[document]
+			return found, found.pair.Key + documentSeparator + path, []byte(value), true
+		}
+	}
+	if found, ok := snap.values[key]; ok {
+		return found, found.pair.Key, found.pair.Value, true
+	}
+	return entry{}, "", nil, false
+}
+
+func isHidden(key string, layerPrefix string, hidden []string) bool {
+	for _, prefix := range hidden {
+		if strings.HasPrefix(key, prefix) && !strings.HasPrefix(layerPrefix, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// nestedLayers returns the prefixes of the other layers that are beneath the given one.
+func nestedLayers(layers []string, i int, delimiter string) []string {
+	prefix := layerPrefix(layers[i], delimiter)
+	var nested []string
+	for j, layer := range layers {
+		other := layerPrefix(layer, delimiter)
+		if j != i && other != prefix && strings.HasPrefix(other, prefix) {
+			nested = append(nested, other)
+		}
+	}
+	return nested
+}
+
+// layerPrefix is what we expect at the start of every qualified key in the layer.
+func layerPrefix(layer string, delimiter string) string {
+	if layer == "" {
+		return ""
+	}
+	return layer + delimiter
+}
+
+// snapshotSource is a read-only configify.Source that looks up values from a single snapshot. It
+// does all of the real work of parsing values; the consulSource just hands its lookups off to
+// one of these for the latest snapshot.
+type snapshotSource struct {
+	snapshot *snapshot
+	options  configify.Options
+	massage  configify.Massage
+	schema   *compiledSchema
+	strict   bool
+}
+
+func (s snapshotSource) Options() configify.Options {
+	return s.options
+}
+
+func (s snapshotSource) lookup(key string) (string, bool) {
+	if _, _, value, ok := s.snapshot.find(key); ok {
+		return strings.TrimSpace(string(value)), true
+	}
+	// Schema defaults win over the Defaults option since they're specific to this source.
+	if value, ok := s.schema.defaults[mapLookupKey(s.snapshot.mapKey, key)]; ok {
+		return value, true
+	}
+	return "", false
+}
+
+// fits returns false when strict parsing is on and the value doesn't fit in the type you asked for
+// (e.g. "70000" as an int16). Otherwise, we stick w/ Go's usual wrap-around conversions.
+func (s snapshotSource) fits(fieldType FieldType, value string) bool {
+	if !s.strict {
+		return true
+	}
+	_, _, err := parseField(fieldType, value, s.massage)
+	return err == nil
+}
+
+// Check tells you whether the key has a value that parses as the given type w/o overflowing. The
+// error is a *LookupError whose Err tells you whether the key is missing (from Consul and your
+// schema defaults), malformed or out of range for the type.
+func (s snapshotSource) Check(key string, fieldType FieldType) error {
+	if err := s.diagnose(key, fieldType); err != nil {
+		return err
+	}
+	return nil
+}
+
+// diagnose explains why the key's value (from Consul or your schema defaults) isn't usable as the
+// given type. It returns nil when the value is fine.
+func (s snapshotSource) diagnose(key string, fieldType FieldType) *LookupError {
+	qualifiedKey := s.options.Namespace.Qualify(key)
+	value, ok := s.lookup(key)
+	if !ok {
+		return &LookupError{Key: key, QualifiedKey: qualifiedKey, Type: fieldType, Err: ErrMissing}
+	}
+	_, _, err := parseField(fieldType, value, s.massage)
+	if err == nil {
+		return nil
+	}
+	lookupErr := &LookupError{Key: key, QualifiedKey: qualifiedKey, Value: value, Type: fieldType, Err: err}
+	if found, foundKey, _, ok := s.snapshot.find(key); ok {
+		lookupErr.QualifiedKey = foundKey
+		lookupErr.ModifyIndex = found.pair.ModifyIndex
+	}
+	return lookupErr
+}
+
+func (s snapshotSource) String(key string) (string, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.String(key)
+	}
+	return value, true
+}
+
+func (s snapshotSource) StringSlice(key string) ([]string, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.StringSlice(key)
+	}
+	return s.massage.StringToSlice(value)
+}
+
+func (s snapshotSource) Int(key string) (int, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Int(key)
+	}
+	number, ok := s.massage.StringToInt64(value)
+	if !ok || !s.fits(TypeInt, value) {
+		return 0, false
+	}
+	return int(number), true
+}
+
+func (s snapshotSource) Int8(key string) (int8, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Int8(key)
+	}
+	number, ok := s.massage.StringToInt64(value)
+	if !ok || !s.fits(TypeInt8, value) {
+		return 0, false
+	}
+	return int8(number), true
+}
+
+func (s snapshotSource) Int16(key string) (int16, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Int16(key)
+	}
+	number, ok := s.massage.StringToInt64(value)
+	if !ok || !s.fits(TypeInt16, value) {
+		return 0, false
+	}
+	return int16(number), true
+}
+
+func (s snapshotSource) Int32(key string) (int32, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Int32(key)
+	}
+	number, ok := s.massage.StringToInt64(value)
+	if !ok || !s.fits(TypeInt32, value) {
+		return 0, false
+	}
+	return int32(number), true
+}
+
+func (s snapshotSource) Int64(key string) (int64, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Int64(key)
+	}
+	return s.massage.StringToInt64(value)
+}
+
+func (s snapshotSource) Uint(key string) (uint, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Uint(key)
+	}
+	number, ok := s.massage.StringToUint64(value)
+	if !ok || !s.fits(TypeUint, value) {
+		return 0, false
+	}
+	return uint(number), true
+}
+
+func (s snapshotSource) Uint8(key string) (uint8, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Uint8(key)
+	}
+	number, ok := s.massage.StringToUint64(value)
+	if !ok || !s.fits(TypeUint8, value) {
+		return 0, false
+	}
+	return uint8(number), true
+}
+
+func (s snapshotSource) Uint16(key string) (uint16, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Uint16(key)
+	}
+	number, ok := s.massage.StringToUint64(value)
+	if !ok || !s.fits(TypeUint16, value) {
+		return 0, false
+	}
+	return uint16(number), true
+}
+
+func (s snapshotSource) Uint32(key string) (uint32, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Uint32(key)
+	}
+	number, ok := s.massage.StringToUint64(value)
+	if !ok || !s.fits(TypeUint32, value) {
+		return 0, false
+	}
+	return uint32(number), true
+}
+
+func (s snapshotSource) Uint64(key string) (uint64, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Uint64(key)
+	}
+	return s.massage.StringToUint64(value)
+}
+
+func (s snapshotSource) Float32(key string) (float32, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Float32(key)
+	}
+	number, ok := s.massage.StringToFloat64(value)
+	if !ok || !s.fits(TypeFloat32, value) {
+		return 0, false
+	}
+	return float32(number), true
+}
+
+func (s snapshotSource) Float64(key string) (float64, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Float64(key)
+	}
+	return s.massage.StringToFloat64(value)
+}
+
+func (s snapshotSource) Bool(key string) (bool, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Bool(key)
+	}
+	return s.massage.StringToBool(value)
+}
+
+func (s snapshotSource) Duration(key string) (time.Duration, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Duration(key)
+	}
+	return s.massage.StringToDuration(value)
+}
+
+func (s snapshotSource) Time(key string) (time.Time, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
+		return s.options.Defaults.Time(key)
+	}
+	return s.massage.StringToTime(value)
+}
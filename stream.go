@@ -0,0 +1,169 @@
+package consul
+
+import (
+	"context"
+	"sync"
+)
+
+// OverflowPolicy decides what a change stream does when you're not reading events as fast as
+// we're producing them and its buffer fills up.
+type OverflowPolicy int
+
+const (
+	// Coalesce merges new changes into the most recent buffered event, so you never miss a change
+	// and you never slow down the source, but you might receive fewer (bigger) events. This is
+	// the default since it's the safest option.
+	Coalesce OverflowPolicy = iota
+	// Block makes the source wait until you've made room in the buffer. Every change set is
+	// delivered individually, but a slow reader holds up refreshes and every other watcher.
+	Block
+)
+
+// ChangeEvent is what you receive from a Changes() stream.
+type ChangeEvent struct {
+	ChangeSet
+	// Coalesced is the number of change sets that were merged into this event because the buffer
+	// was full. It's 1 when this event describes a single refresh.
+	Coalesced int
+}
+
+// StreamOption customizes the buffering behavior of a Changes() stream.
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	buffer   int
+	overflow OverflowPolicy
+}
+
+// StreamBuffer sets how many events a Changes() stream holds on to while waiting for you to read
+// them. The default is 16.
+func StreamBuffer(size int) StreamOption {
+	return func(options *streamOptions) {
+		if size > 0 {
+			options.buffer = size
+		}
+	}
+}
+
+// StreamOverflow sets what a Changes() stream does when its buffer is full. The default is Coalesce.
+func StreamOverflow(policy OverflowPolicy) StreamOption {
+	return func(options *streamOptions) {
+		options.overflow = policy
+	}
+}
+
+// Changes returns a channel that receives an event for every set of changes we detect in your
+// namespace. This is handy when you want to handle changes in a select loop rather than in a
+// callback. The channel is closed when either your context or the source's context is done.
+func (c *consulSource) Changes(ctx context.Context, opts ...StreamOption) <-chan ChangeEvent {
+	options := streamOptions{buffer: 16, overflow: Coalesce}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	s := &stream{
+		options: options,
+		out:     make(chan ChangeEvent),
+		ready:   make(chan struct{}, 1),
+		drained: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	unsubscribe := c.WatchChanges(s.push)
+	go s.pump(ctx, c.options.Context, unsubscribe)
+	return s.out
+}
+
+// stream buffers change sets between the refresh goroutine (which push()-es them) and your
+// goroutine (which reads them from the channel that pump() feeds).
+type stream struct {
+	options streamOptions
+	mutex   sync.Mutex
+	queue   []ChangeEvent
+	out     chan ChangeEvent
+	ready   chan struct{}
+	drained chan struct{}
+	done    chan struct{}
+}
+
+// push buffers the change set according to the stream's overflow policy.
+func (s *stream) push(changes ChangeSet) {
+	for {
+		s.mutex.Lock()
+		switch {
+		case len(s.queue) < s.options.buffer:
+			s.queue = append(s.queue, ChangeEvent{ChangeSet: changes, Coalesced: 1})
+		case s.options.overflow == Coalesce:
+			last := &s.queue[len(s.queue)-1]
+			last.ChangeSet = merge(last.ChangeSet, changes)
+			last.Coalesced++
+			// Things changed back to how they were (e.g. added then deleted), so there's
+			// nothing left to tell you about.
+			if len(last.Changes) == 0 {
+				s.queue = s.queue[:len(s.queue)-1]
+			}
+		default:
+			// Block until the reader makes some room (or goes away).
+			s.mutex.Unlock()
+			select {
+			case <-s.drained:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		s.mutex.Unlock()
+		signal(s.ready)
+		return
+	}
+}
+
+// pump feeds buffered events to the reader until either context is done.
+func (s *stream) pump(ctx context.Context, sourceCtx context.Context, unsubscribe func()) {
+	defer close(s.out)
+	defer close(s.done)
+	defer unsubscribe()
+
+	for {
+		event, ok := s.pop()
+		if !ok {
+			select {
+			case <-s.ready:
+				continue
+			case <-ctx.Done():
+				return
+			case <-sourceCtx.Done():
+				return
+			}
+		}
+
+		select {
+		case s.out <- event:
+		case <-ctx.Done():
+			return
+		case <-sourceCtx.Done():
+			return
+		}
+	}
+}
+
+func (s *stream) pop() (ChangeEvent, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.queue) == 0 {
+		return ChangeEvent{}, false
+	}
+	event := s.queue[0]
+	s.queue = s.queue[1:]
+	signal(s.drained)
+	return event, true
+}
+
+// signal does a non-blocking send on a channel w/ a buffer of 1 so that the receiver knows that
+// there's something to do, w/o the sender having to wait for the receiver.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,129 @@
+package consul
+
+import (
+	"strings"
+	"time"
+
+	"github.com/robsignorelli/configify"
+)
+
+// Sub returns a source scoped to a group of keys beneath your namespace (e.g. Sub("db") sees
+// "FOO/db/HOST" as "HOST"), so you can hand a package only the settings it cares about. It
+// shares this source's refresh loop and snapshots rather than polling Consul on its own, and
+// its watchers only fire when something beneath the prefix changes.
+func (c *consulSource) Sub(prefix string) configify.SourceWatcher {
+	return &subWatcher{
+		subSource: newSubSource(c, prefix),
+		parent:    c,
+	}
+}
+
+// subSource is a view of another source that qualifies every key w/ an extra prefix.
+type subSource struct {
+	source configify.Source
+	name   string
+}
+
+func newSubSource(source configify.Source, name string) subSource {
+	return subSource{source: source, name: name}
+}
+
+// qualify joins the key onto the sub-source's name just like configify joins a key onto your
+// namespace (e.g. "HOST" becomes "db_HOST" when you don't set a delimiter).
+func (s subSource) qualify(key string) string {
+	return s.source.Options().Namespace.Join(s.name, key)
+}
+
+// Options describes the sub-source as though it were a source w/ a longer namespace.
+func (s subSource) Options() configify.Options {
+	options := s.source.Options()
+	options.Namespace.Name = options.Namespace.Qualify(s.name)
+	return options
+}
+
+func (s subSource) String(key string) (string, bool) {
+	return s.source.String(s.qualify(key))
+}
+
+func (s subSource) StringSlice(key string) ([]string, bool) {
+	return s.source.StringSlice(s.qualify(key))
+}
+
+func (s subSource) Int(key string) (int, bool) {
+	return s.source.Int(s.qualify(key))
+}
+
+func (s subSource) Int8(key string) (int8, bool) {
+	return s.source.Int8(s.qualify(key))
+}
+
+func (s subSource) Int16(key string) (int16, bool) {
+	return s.source.Int16(s.qualify(key))
+}
+
+func (s subSource) Int32(key string) (int32, bool) {
+	return s.source.Int32(s.qualify(key))
+}
+
+func (s subSource) Int64(key string) (int64, bool) {
+	return s.source.Int64(s.qualify(key))
+}
+
+func (s subSource) Uint(key string) (uint, bool) {
+	return s.source.Uint(s.qualify(key))
+}
+
+func (s subSource) Uint8(key string) (uint8, bool) {
+	return s.source.Uint8(s.qualify(key))
+}
+
+func (s subSource) Uint16(key string) (uint16, bool) {
+	return s.source.Uint16(s.qualify(key))
+}
+
+func (s subSource) Uint32(key string) (uint32, bool) {
+	return s.source.Uint32(s.qualify(key))
+}
+
+func (s subSource) Uint64(key string) (uint64, bool) {
+	return s.source.Uint64(s.qualify(key))
+}
+
+func (s subSource) Float32(key string) (float32, bool) {
+	return s.source.Float32(s.qualify(key))
+}
+
+func (s subSource) Float64(key string) (float64, bool) {
+	return s.source.Float64(s.qualify(key))
+}
+
+func (s subSource) Bool(key string) (bool, bool) {
+	return s.source.Bool(s.qualify(key))
+}
+
+func (s subSource) Duration(key string) (time.Duration, bool) {
+	return s.source.Duration(s.qualify(key))
+}
+
+func (s subSource) Time(key string) (time.Time, bool) {
+	return s.source.Time(s.qualify(key))
+}
+
+// subWatcher is the live version of a subSource that can also tell you when its keys change.
+type subWatcher struct {
+	subSource
+	parent *consulSource
+}
+
+// Watch fires your callback w/ a scoped snapshot whenever any key beneath the prefix changes.
+func (s *subWatcher) Watch(callback func(source configify.Source)) {
+	prefix := s.parent.mapKey(s.name + namespaceDelimiter(s.parent.options))
+	s.parent.WatchChanges(func(changes ChangeSet) {
+		for _, change := range changes.Changes {
+			if strings.HasPrefix(change.Key, prefix) {
+				callback(newSubSource(changes.Source, s.name))
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package consul
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig describes how to establish HTTPS (and optionally mutual TLS) connections to your
+// Consul agent. The certificates are re-read from disk whenever their files change, so you can
+// rotate them w/o restarting your program.
+type TLSConfig struct {
+	// CAFile is the path to a PEM-encoded CA certificate used to verify the Consul agent.
+	CAFile string
+	// CAPath is a directory of PEM-encoded CA certificates used to verify the Consul agent.
+	CAPath string
+	// CertFile is the path to the PEM-encoded client certificate for mutual TLS.
+	CertFile string
+	// KeyFile is the path to the PEM-encoded private key for CertFile.
+	KeyFile string
+	// ServerName is the host name we expect in the agent's certificate. It defaults to the
+	// host portion of the Address option.
+	ServerName string
+	// InsecureSkipVerify turns off verification of the agent's certificate. Don't use this
+	// for anything other than local development.
+	InsecureSkipVerify bool
+}
+
+func (config TLSConfig) enabled() bool {
+	return config.CAFile != "" ||
+		config.CAPath != "" ||
+		config.CertFile != "" ||
+		config.KeyFile != "" ||
+		config.ServerName != "" ||
+		config.InsecureSkipVerify
+}
+
+// newTLSClientConfig builds the tls.Config for talking to the agent at the given address. We
+// load all of the certificates up front so that bad paths are reported right away rather than
+// when we make our first request.
+func newTLSClientConfig(config TLSConfig, address string) (*tls.Config, error) {
+	if config.CertFile == "" && config.KeyFile != "" || config.CertFile != "" && config.KeyFile == "" {
+		return nil, errors.New("client certificate and key must be provided together")
+	}
+
+	reloader := &tlsReloader{config: config}
+	if _, err := reloader.clientCertificate(nil); err != nil {
+		return nil, err
+	}
+	if _, err := reloader.rootCAs(); err != nil {
+		return nil, err
+	}
+
+	serverName := config.ServerName
+	if serverName == "" {
+		serverName = hostOf(address)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CertFile != "" {
+		tlsConfig.GetClientCertificate = reloader.clientCertificate
+	}
+	// The standard library only lets you supply a fixed set of root CAs, so when you've given
+	// us your own we do the verification ourselves against the latest CAs on disk.
+	if !config.InsecureSkipVerify && (config.CAFile != "" || config.CAPath != "") {
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return reloader.verify(rawCerts, serverName)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// hostOf strips the scheme and port from the address, leaving just the host name.
+func hostOf(address string) string {
+	if parts := strings.SplitN(address, "://", 2); len(parts) == 2 {
+		address = parts[1]
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// tlsReloader hangs onto the most recently loaded certificates, re-reading them only when the
+// files on disk have been modified since we last looked.
+type tlsReloader struct {
+	config   TLSConfig
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	certMod  time.Time
+	roots    *x509.CertPool
+	rootsMod time.Time
+}
+
+func (r *tlsReloader) clientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if r.config.CertFile == "" {
+		return &tls.Certificate{}, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	modified, err := lastModified(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read client certificate")
+	}
+	if r.cert != nil && modified.Equal(r.certMod) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load client certificate")
+	}
+	r.cert = &cert
+	r.certMod = modified
+	return r.cert, nil
+}
+
+func (r *tlsReloader) rootCAs() (*x509.CertPool, error) {
+	if r.config.CAFile == "" && r.config.CAPath == "" {
+		return nil, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	files, err := caFiles(r.config.CAFile, r.config.CAPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read CA certificates")
+	}
+	// Include the directory itself so that adding/removing a CA is noticed, too.
+	watched := files
+	if r.config.CAPath != "" {
+		watched = append(watched, r.config.CAPath)
+	}
+	modified, err := lastModified(watched...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read CA certificates")
+	}
+	if r.roots != nil && modified.Equal(r.rootsMod) {
+		return r.roots, nil
+	}
+
+	roots := x509.NewCertPool()
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read CA certificate")
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificates found in %s", file)
+		}
+	}
+	r.roots = roots
+	r.rootsMod = modified
+	return r.roots, nil
+}
+
+// verify does the standard certificate chain and host name verification that crypto/tls
+// normally does for us, but against the latest version of your CA certificates.
+func (r *tlsReloader) verify(rawCerts [][]byte, serverName string) error {
+	roots, err := r.rootCAs()
+	if err != nil {
+		return err
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		if certs[i], err = x509.ParseCertificate(raw); err != nil {
+			return errors.Wrap(err, "unable to parse server certificate")
+		}
+	}
+	if len(certs) == 0 {
+		return errors.New("server did not provide a certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// caFiles returns the CA file (if any) along w/ every file in the CA directory (if any).
+func caFiles(caFile string, caPath string) ([]string, error) {
+	var files []string
+	if caFile != "" {
+		files = append(files, caFile)
+	}
+	if caPath == "" {
+		return files, nil
+	}
+
+	infos, err := ioutil.ReadDir(caPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			files = append(files, filepath.Join(caPath, info.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("no CA certificates found in %s", caPath)
+	}
+	return files, nil
+}
+
+// lastModified returns the most recent modification time of all of the given files.
+func lastModified(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
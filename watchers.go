@@ -0,0 +1,117 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robsignorelli/configify"
+)
+
+// watcher is a single registered change callback. We compare these by pointer when
+// unsubscribing, since you can't compare funcs in Go.
+type watcher struct {
+	callback func(changes ChangeSet)
+}
+
+// Watch registers a callback that fires whenever we detect changes to your namespace. You can
+// register as many as you like. Since the configify.SourceWatcher interface doesn't give us a way
+// to hand you an unsubscribe function, use WatchChanges if you need to stop watching later.
+func (c *consulSource) Watch(callback func(source configify.Source)) {
+	c.WatchChanges(func(changes ChangeSet) {
+		callback(changes.Source)
+	})
+}
+
+func (c *consulSource) WatchChanges(callback func(changes ChangeSet)) (unsubscribe func()) {
+	w := &watcher{callback: callback}
+
+	c.watcherMutex.Lock()
+	defer c.watcherMutex.Unlock()
+
+	// Copy-on-write so that notify() can iterate over its copy w/o holding the lock.
+	watchers := make([]*watcher, 0, len(c.watchers)+1)
+	c.watchers = append(append(watchers, c.watchers...), w)
+
+	return func() {
+		c.watcherMutex.Lock()
+		defer c.watcherMutex.Unlock()
+
+		watchers := make([]*watcher, 0, len(c.watchers))
+		for _, existing := range c.watchers {
+			if existing != w {
+				watchers = append(watchers, existing)
+			}
+		}
+		c.watchers = watchers
+	}
+}
+
+// WatchKey registers a callback that only fires when the given key (relative to your namespace)
+// is added, modified or deleted. It returns a function you can call to stop watching.
+func (c *consulSource) WatchKey(key string, callback func(change KeyChange)) (unsubscribe func()) {
+	key = mapLookupKey(c.mapKey, key)
+	return c.watchMatching(func(changedKey string) bool { return changedKey == key }, callback)
+}
+
+// WatchPrefix registers a callback that fires once for each added, modified or deleted key that
+// starts w/ the given prefix (relative to your namespace). It returns a function you can call
+// to stop watching.
+func (c *consulSource) WatchPrefix(prefix string, callback func(change KeyChange)) (unsubscribe func()) {
+	prefix = c.mapKey(prefix)
+	return c.watchMatching(func(changedKey string) bool { return strings.HasPrefix(changedKey, prefix) }, callback)
+}
+
+func (c *consulSource) watchMatching(matches func(key string) bool, callback func(change KeyChange)) func() {
+	return c.WatchChanges(func(changes ChangeSet) {
+		for _, change := range changes.Changes {
+			if matches(change.Key) {
+				callback(KeyChange{Change: change, Old: changes.Previous, New: changes.Source})
+			}
+		}
+	})
+}
+
+// deliver hands the changes off to your watchers, waiting for things to quiet down first if
+// you've asked us to debounce notifications.
+func (c *consulSource) deliver(changes ChangeSet) {
+	// Nobody was around to hear about these changes (e.g. our initial load in NewSource), so
+	// don't let the debouncer hold onto them for watchers that come along later.
+	c.watcherMutex.RLock()
+	watching := len(c.watchers) > 0
+	c.watcherMutex.RUnlock()
+	if !watching {
+		return
+	}
+
+	if c.debouncer != nil {
+		c.debouncer.push(changes)
+		return
+	}
+	c.notify(changes)
+}
+
+// notify fires every registered watcher in the order they were registered.
+func (c *consulSource) notify(changes ChangeSet) {
+	c.watcherMutex.RLock()
+	watchers := c.watchers
+	c.watcherMutex.RUnlock()
+
+	for _, w := range watchers {
+		c.notifyWatcher(w, changes)
+	}
+}
+
+// notifyWatcher fires a single watcher, making sure that a panic doesn't take down the refresh
+// goroutine or keep the rest of the watchers from hearing about the changes.
+func (c *consulSource) notifyWatcher(w *watcher, changes ChangeSet) {
+	defer func() {
+		if recovered := recover(); recovered != nil && c.onError != nil {
+			c.onError(&RefreshError{
+				Kind:      KindWatcher,
+				Namespace: c.options.Namespace.Name,
+				Err:       fmt.Errorf("watcher panicked: %v", recovered),
+			})
+		}
+	}()
+	w.callback(changes)
+}